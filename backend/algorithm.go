@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sort"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	defaultAlgorithm = "RS256"
+	defaultRSABits   = 2048
+)
+
+var validRSABits = []int{2048, 3072, 4096}
+
+type algorithm struct {
+	Method   jwt.SigningMethod
+	Generate func(conf *Config) (crypto.Signer, error)
+}
+
+var algorithms = map[string]*algorithm{
+	"RS256": {jwt.SigningMethodRS256, generateRSAKey},
+	"RS384": {jwt.SigningMethodRS384, generateRSAKey},
+	"RS512": {jwt.SigningMethodRS512, generateRSAKey},
+	"PS256": {jwt.SigningMethodPS256, generateRSAKey},
+	"PS384": {jwt.SigningMethodPS384, generateRSAKey},
+	"PS512": {jwt.SigningMethodPS512, generateRSAKey},
+	"ES256": {jwt.SigningMethodES256, generateECDSAKey(elliptic.P256())},
+	"ES384": {jwt.SigningMethodES384, generateECDSAKey(elliptic.P384())},
+	"ES512": {jwt.SigningMethodES512, generateECDSAKey(elliptic.P521())},
+	"EdDSA": {SigningMethodEd25519, generateEd25519Key},
+}
+
+func getAlgorithm(name string) (*algorithm, error) {
+	if name == "" {
+		name = defaultAlgorithm
+	}
+
+	alg := algorithms[name]
+	if alg == nil {
+		return nil, fmt.Errorf("unsupported algorithm %q", name)
+	}
+
+	return alg, nil
+}
+
+// accepts reports whether the algorithm can sign with a key.
+func (a *algorithm) accepts(pub crypto.PublicKey) bool {
+	switch m := a.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok := pub.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		k, ok := pub.(*ecdsa.PublicKey)
+		return ok && k.Curve.Params().BitSize == m.CurveBits
+	case *SigningMethodEdDSA:
+		_, ok := pub.(ed25519.PublicKey)
+		return ok
+	default:
+		return false
+	}
+}
+
+// algorithmFor returns the default algorithm for a key.
+func algorithmFor(pub crypto.PublicKey) (string, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return "ES256", nil
+		case 384:
+			return "ES384", nil
+		case 521:
+			return "ES512", nil
+		}
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	}
+	return "", errors.New("unsupported key type")
+}
+
+func supportedAlgorithms() []string {
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func generateRSAKey(conf *Config) (crypto.Signer, error) {
+	bits := conf.RSABits
+	if bits == 0 {
+		bits = defaultRSABits
+	}
+	return rsa.GenerateKey(rand.Reader, bits)
+}
+
+func generateECDSAKey(curve elliptic.Curve) func(conf *Config) (crypto.Signer, error) {
+	return func(conf *Config) (crypto.Signer, error) {
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	}
+}
+
+func generateEd25519Key(conf *Config) (crypto.Signer, error) {
+	_, prv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return prv, nil
+}
+
+// keySize returns the size in bits of a public key.
+func keySize(key interface{}) int {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	default:
+		return 0
+	}
+}
@@ -2,8 +2,13 @@ package backend
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -24,26 +29,132 @@ func Backend(conf *logical.BackendConfig) *backend {
 	b.Backend = &framework.Backend{
 		Help: "",
 		Paths: framework.PathAppend(
+			configPaths(&b),
 			keyPaths(&b),
+			keyRingPaths(&b),
+			revokePaths(&b),
+			certificatePaths(&b),
+			tidyPaths(&b),
+			backupPaths(&b),
+			importPaths(&b),
+			jwksPaths(&b),
 			rolePaths(&b),
 		),
 		PathsSpecial: &logical.Paths{
-			Unauthenticated: []string{"key/"},
-			SealWrapStorage: []string{"privatekey"},
+			Unauthenticated: []string{
+				"key/",
+				"revoked/*",
+				"jwks",
+				".well-known/jwks.json",
+				".well-known/openid-configuration",
+			},
+			SealWrapStorage: []string{
+				"privatekey",
+				"privatekey/",
+				"nextkey",
+				"nextkey/",
+				"importedkey/",
+			},
 		},
 		Secrets:      []*framework.Secret{},
 		BackendType:  logical.TypeLogical,
 		PeriodicFunc: b.periodic,
+		Invalidate:   b.invalidate,
 	}
 
+	b.keyRings.readOnly = b.storageReadOnly
+
 	return &b
 }
 
 type backend struct {
 	*framework.Backend
-	currentKey
+	keyRings
+
+	importedKeys  importedKeys
+	tokenExpiries tokenExpiries
+
+	tidyMtx    sync.Mutex
+	tidyErrors []string
+}
+
+// invalidate is called when another node in the cluster changes storage.
+func (b *backend) invalidate(ctx context.Context, key string) {
+	switch {
+	case key == "privatekey":
+		b.Ring(defaultKeyRing).Invalidate("")
+	case strings.HasPrefix(key, "privatekey/"):
+		b.Ring(strings.TrimPrefix(key, "privatekey/")).Invalidate("")
+	case strings.HasPrefix(key, "importedkey/"):
+		b.importedKeys.Invalidate(strings.TrimPrefix(key, "importedkey/"))
+	case strings.HasPrefix(key, "key/"):
+		b.invalidateKey(strings.TrimPrefix(key, "key/"))
+	}
+}
+
+// storageReadOnly reports whether this node is a performance standby or a
+// performance secondary that can not write to the (replicated) mount storage.
+func (b *backend) storageReadOnly() bool {
+	sys := b.System()
+	if sys == nil {
+		return false
+	}
+
+	state := sys.ReplicationState()
+	return state.HasState(consts.ReplicationPerformanceStandby) ||
+		(!sys.LocalMount() && state.HasState(consts.ReplicationPerformanceSecondary))
 }
 
 func (b *backend) periodic(ctx context.Context, req *logical.Request) error {
-	return b.cleanExpiredPublicKeys(ctx, req, time.Now())
+	var (
+		result error
+		now    = time.Now()
+	)
+
+	if b.storageReadOnly() {
+		return nil
+	}
+
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	err = b.migrateLegacyKeys(ctx, req)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	rings, err := b.listKeyRings(ctx, req)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	for _, name := range rings {
+		ringConf, err := b.getKeyRingConfig(ctx, req, conf, name)
+		if err == nil && ringConf != nil {
+			err = b.Ring(name).Tick(ctx, req, ringConf, now)
+		}
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("key ring %s: %v", name, err))
+		}
+	}
+
+	err = b.refreshImportedKeys(ctx, req, conf, now)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	err = b.writeTokenExpiries(ctx, req, conf)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	// tidy logs its own failures
+	_, err = b.tidy(ctx, req, now, false)
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	return result
 }
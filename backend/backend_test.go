@@ -2,16 +2,20 @@ package backend
 
 import (
 	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/logical"
 )
 
@@ -31,24 +35,112 @@ var (
 	}()
 )
 
+// newTestBackend returns a new backend and empty storage for a single test.
+func newTestBackend(t *testing.T) (*backend, logical.Storage) {
+	t.Helper()
+
+	conf := &logical.BackendConfig{System: &logical.StaticSystemView{}}
+	b := Backend(conf)
+	if err := b.Setup(testCtx, conf); err != nil {
+		t.Fatal(err)
+	}
+
+	return b, &logical.InmemStorage{}
+}
+
 func TestBackend(t *testing.T) {
 
+	// Exercise the mount configuration.
+	t.Run("read config", ReadConfig)
+	t.Run("write invalid config", WriteInvalidConfig)
+
 	// Exercise all role endpoints.
 	t.Run("write role", WriteRole)
 	t.Run("read role", ReadRole)
+	t.Run("update role", UpdateRole)
 	t.Run("list roles", ListRoles)
 	t.Run("delete role", DeleteRole)
 
 	// Plant a role for further testing.
 	t.Run("plant role", WriteRole)
 
+	// The first signing key is generated on demand.
+	t.Run("generate keys", GenerateKeys)
+
 	// Plant a role for further testing.
 	t.Run("sign with role", Sign)
 
+	t.Run("read jwks", ReadJWKS)
+
+	t.Run("read discovery", ReadDiscovery)
+
+	t.Run("rotate key", RotateKey)
+
+	t.Run("list keys", ListKeys)
+
 	t.Run("expire keys", ExpireKeys)
 
 }
 
+func ReadConfig(t *testing.T) {
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   testStorage,
+	}
+	resp, err := testBackend.HandleRequest(testCtx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"issuer":             "",
+		"key_ttl":            86400,
+		"next_key_lead_time": 3600,
+		"key_retention":      2678400,
+		"default_ttl":        3600,
+		"max_ttl":            86400,
+		"key_id_format":      "uuid",
+		"grace_period":       3600,
+		"allow_backup":       false,
+
+		"redact_revocation_reason": true,
+		"self_signed_certificates": false,
+	}
+	for k, v := range expected {
+		if resp.Data[k] != v {
+			t.Errorf("expected %s to be %v but received %v", k, v, resp.Data[k])
+		}
+	}
+}
+
+func WriteInvalidConfig(t *testing.T) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   testStorage,
+		Data: map[string]interface{}{
+			"key_ttl":       "24h",
+			"key_retention": "23h",
+		},
+	}
+	resp, err := testBackend.HandleRequest(testCtx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Fatalf("expected a 400 response but received %v", resp)
+	}
+
+	conf, err := testBackend.getConfig(testCtx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.KeyRetention != defaultConfig().KeyRetention {
+		t.Fatal("invalid config should not be stored")
+	}
+}
+
 func WriteRole(t *testing.T) {
 	req := &logical.Request{
 		Operation: logical.CreateOperation,
@@ -81,8 +173,8 @@ func ReadRole(t *testing.T) {
 	}
 
 	// Did we get the response data we expect?
-	if len(resp.Data) != 5 {
-		t.Fatalf("expected 5 items in %s but received %d", resp.Data, len(resp.Data))
+	if len(resp.Data) != 14 {
+		t.Fatalf("expected 14 items in %s but received %d", resp.Data, len(resp.Data))
 	}
 	if resp.Data["name"] != "foo" {
 		t.Fatalf("expected \"foo\" but received %q", resp.Data["name"])
@@ -101,6 +193,46 @@ func ReadRole(t *testing.T) {
 	}
 }
 
+func UpdateRole(t *testing.T) {
+	update := func(data map[string]interface{}) *Role {
+		t.Helper()
+
+		resp, err := testBackend.HandleRequest(testCtx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/foo",
+			Storage:   testStorage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatal(err, resp)
+		}
+
+		role, err := testBackend.getRole(testCtx, &logical.Request{Storage: testStorage}, "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return role
+	}
+
+	// Omitted fields keep their values.
+	role := update(map[string]interface{}{"ttl": "10m"})
+	if role.TTL != 600 {
+		t.Errorf("expected ttl 600 but received %d", role.TTL)
+	}
+	if string(role.Defaults) != `{"foo":"bar"}` || string(role.Overrides) != `{"bar":"baz"}` || len(role.Schema) == 0 {
+		t.Fatalf("expected the other fields to be kept but received %+v", role)
+	}
+
+	// Empty values clear fields.
+	role = update(map[string]interface{}{"defaults": "", "schema": ""})
+	if len(role.Defaults) != 0 || len(role.Schema) != 0 {
+		t.Fatalf("expected defaults and schema to be cleared but received %+v", role)
+	}
+	if string(role.Overrides) != `{"bar":"baz"}` || role.TTL != 600 {
+		t.Fatalf("expected overrides and ttl to be kept but received %+v", role)
+	}
+}
+
 func ListRoles(t *testing.T) {
 	req := &logical.Request{
 		Operation: logical.ListOperation,
@@ -140,6 +272,23 @@ func DeleteRole(t *testing.T) {
 	}
 }
 
+func GenerateKeys(t *testing.T) {
+	req := &logical.Request{Storage: testStorage}
+
+	// The first key is generated when it is first needed.
+	key, err := testBackend.Ring(defaultKeyRing).Get(testCtx, req, defaultConfig())
+	assert(t, err)
+
+	// The periodic function doesn't replace it before its lead time.
+	assert(t, testBackend.periodic(testCtx, req))
+
+	current, err := testBackend.Ring(defaultKeyRing).Get(testCtx, req, defaultConfig())
+	assert(t, err)
+	if current.ID != key.ID {
+		t.Fatal("expected the first key to keep signing")
+	}
+}
+
 func Sign(t *testing.T) {
 	req := &logical.Request{
 		Operation: logical.CreateOperation,
@@ -154,8 +303,8 @@ func Sign(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if len(resp.Data) != 2 {
-		t.Fatalf("expected 2 items in %s but received %d", resp.Data, len(resp.Data))
+	if len(resp.Data) != 3 {
+		t.Fatalf("expected 3 items in %s but received %d", resp.Data, len(resp.Data))
 	}
 	if resp.Data["token"] == nil {
 		t.Fatalf("expected \"token\" but received %q", resp.Data["token"])
@@ -175,6 +324,10 @@ func Sign(t *testing.T) {
 			return nil, err
 		}
 
+		if resp.Data["key_size"] != 2048 {
+			return nil, fmt.Errorf("expected a 2048 bit key but received %v", resp.Data["key_size"])
+		}
+
 		block, _ := pem.Decode([]byte(resp.Data["public"].(string)))
 
 		return x509.ParsePKCS1PublicKey(block.Bytes)
@@ -187,6 +340,203 @@ func Sign(t *testing.T) {
 	}
 }
 
+func ReadJWKS(t *testing.T) {
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      ".well-known/jwks.json",
+		Storage:   testStorage,
+	}
+	resp, err := testBackend.HandleRequest(testCtx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err)
+	}
+
+	if resp.Data[logical.HTTPContentType] != "application/json" {
+		t.Fatalf("expected JSON but received %q", resp.Data[logical.HTTPContentType])
+	}
+
+	var jwks JSONWebKeySet
+	err = json.Unmarshal([]byte(resp.Data[logical.HTTPRawBody].(string)), &jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jwks.Keys) != 1 {
+		t.Fatalf("expected 1 key but received %d", len(jwks.Keys))
+	}
+
+	jwk := jwks.Keys[0]
+	if jwk.KeyType != "RSA" || jwk.Algorithm != "RS256" || jwk.Use != "sig" {
+		t.Fatalf("unexpected key parameters %+v", jwk)
+	}
+	if jwk.N == "" || jwk.E != "AQAB" {
+		t.Fatalf("unexpected key material %+v", jwk)
+	}
+
+	key, err := testBackend.getKey(testCtx, req, jwk.KeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == nil {
+		t.Fatalf("expected key %q to be stored", jwk.KeyID)
+	}
+}
+
+func ReadDiscovery(t *testing.T) {
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      ".well-known/openid-configuration",
+		Storage:   testStorage,
+	}
+	resp, err := testBackend.HandleRequest(testCtx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err)
+	}
+	if resp != nil {
+		t.Fatal("expected no discovery document without an issuer")
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   testStorage,
+		Data: map[string]interface{}{
+			"issuer": "https://vault.example.com/v1/jwt/",
+		},
+	}
+	resp, err = testBackend.HandleRequest(testCtx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      ".well-known/openid-configuration",
+		Storage:   testStorage,
+	}
+	resp, err = testBackend.HandleRequest(testCtx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err)
+	}
+
+	var doc map[string]interface{}
+	err = json.Unmarshal([]byte(resp.Data[logical.HTTPRawBody].(string)), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc["issuer"] != "https://vault.example.com/v1/jwt" {
+		t.Fatalf("expected issuer but received %q", doc["issuer"])
+	}
+	if doc["jwks_uri"] != "https://vault.example.com/v1/jwt/.well-known/jwks.json" {
+		t.Fatalf("expected jwks_uri but received %q", doc["jwks_uri"])
+	}
+}
+
+func RotateKey(t *testing.T) {
+	oldKey, err := testBackend.Ring(defaultKeyRing).Get(testCtx, &logical.Request{Storage: testStorage}, defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rotate",
+		Storage:   testStorage,
+	}
+	resp, err := testBackend.HandleRequest(testCtx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err)
+	}
+
+	newKeyID := resp.Data["name"].(string)
+	if newKeyID == "" || newKeyID == oldKey.ID {
+		t.Fatalf("expected a new key but received %q", newKeyID)
+	}
+
+	for _, keyID := range []string{oldKey.ID, newKeyID} {
+		key, err := testBackend.getKey(testCtx, req, keyID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key == nil {
+			t.Fatalf("expected key %q to be published", keyID)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "sign/foo",
+		Storage:   testStorage,
+	}
+	resp, err = testBackend.HandleRequest(testCtx, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err)
+	}
+
+	token, _ := jwt.Parse(resp.Data["token"].(string), nil)
+	if token == nil || token.Header["kid"] != newKeyID {
+		t.Fatalf("expected token to be signed with %q", newKeyID)
+	}
+}
+
+func ListKeys(t *testing.T) {
+	resp, err := testBackend.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "key/",
+		Storage:   testStorage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err)
+	}
+
+	keyIDs := resp.Data["keys"].([]string)
+	if len(keyIDs) != 2 {
+		t.Fatalf("expected 2 keys but received %v", keyIDs)
+	}
+
+	current, err := testBackend.Ring(defaultKeyRing).Get(testCtx, &logical.Request{Storage: testStorage}, defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keyID := range keyIDs {
+		resp, err := testBackend.HandleRequest(testCtx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "key/" + keyID,
+			Storage:   testStorage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatal(err)
+		}
+
+		if resp.Data["active"] != (keyID == current.ID) {
+			t.Errorf("%s: unexpected active flag %v", keyID, resp.Data["active"])
+		}
+		if resp.Data["created"] == nil || resp.Data["expires"] == nil {
+			t.Errorf("%s: expected created and expires in %v", keyID, resp.Data)
+		}
+
+		if keyID != current.ID {
+			continue
+		}
+
+		jwk, err := newJSONWebKey("", current.Algorithm, current.prvKey.Public())
+		assert(t, err)
+		thumbprint, err := jwk.Thumbprint()
+		assert(t, err)
+		if resp.Data["thumbprint"] != thumbprint {
+			t.Errorf("expected thumbprint %q but received %q", thumbprint, resp.Data["thumbprint"])
+		}
+
+		fingerprint, err := spkiFingerprint(current.prvKey.Public())
+		assert(t, err)
+		if resp.Data["spki_sha256"] != fingerprint {
+			t.Errorf("expected fingerprint %q but received %q", fingerprint, resp.Data["spki_sha256"])
+		}
+	}
+}
+
 func ExpireKeys(t *testing.T) {
 	storage := &logical.InmemStorage{}
 	backend := &backend{}
@@ -213,17 +563,17 @@ func ExpireKeys(t *testing.T) {
 
 	now := time.Date(2012, 5, 1, 0, 0, 0, 0, time.UTC)
 
-	err := writePublicKey(ctx, req, genUUID(), genKey(), now.AddDate(0, 0, 1))
+	err := writePublicKey(ctx, req, genUUID(), "RS256", genKey(), nil, now.AddDate(0, 0, 1))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = writePublicKey(ctx, req, genUUID(), genKey(), now.AddDate(0, 0, -1))
+	err = writePublicKey(ctx, req, genUUID(), "RS256", genKey(), nil, now.AddDate(0, 0, -1))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = writePublicKey(ctx, req, genUUID(), genKey(), now.AddDate(0, 0, -2))
+	err = writePublicKey(ctx, req, genUUID(), "RS256", genKey(), nil, now.AddDate(0, 0, -2))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -236,10 +586,21 @@ func ExpireKeys(t *testing.T) {
 		t.Error("expected three keys")
 	}
 
-	err = backend.cleanExpiredPublicKeys(ctx, req, now)
+	jwks, err := backend.getJWKS(ctx, req, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 1 {
+		t.Errorf("expected 1 published key but got %d", len(jwks.Keys))
+	}
+
+	deleted, err := backend.cleanExpiredPublicKeys(ctx, req, now)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(deleted) != 2 {
+		t.Errorf("expected 2 deleted keys but got %d", len(deleted))
+	}
 
 	keys, err = storage.List(ctx, "key/")
 	if err != nil {
@@ -249,3 +610,787 @@ func ExpireKeys(t *testing.T) {
 		t.Errorf("expected 1 key but got %d", len(keys))
 	}
 }
+
+func TestNextKey(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := &backend{}
+	req := &logical.Request{Storage: storage}
+	conf := defaultConfig()
+
+	key, err := b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Ring(defaultKeyRing).Tick(testCtx, req, conf, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := readPrivateKey(testCtx, req, "nextkey/default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != nil {
+		t.Fatal("expected no next key before the lead time")
+	}
+
+	err = b.Ring(defaultKeyRing).Tick(testCtx, req, conf, key.Expires.Add(-30*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err = readPrivateKey(testCtx, req, "nextkey/default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == nil {
+		t.Fatal("expected a next key within the lead time")
+	}
+	published, err := b.getKey(testCtx, req, next.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published == nil {
+		t.Fatal("expected the next key to be published")
+	}
+	current, err := b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.ID != key.ID {
+		t.Fatal("expected the current key to remain active within the lead time")
+	}
+
+	err = b.Ring(defaultKeyRing).Tick(testCtx, req, conf, key.Expires.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err = readPrivateKey(testCtx, req, "privatekey/default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.ID != next.ID {
+		t.Fatal("expected the next key to be promoted")
+	}
+	remaining, err := readPrivateKey(testCtx, req, "nextkey/default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != nil {
+		t.Fatal("expected the next key to be consumed")
+	}
+	current, err = b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.ID != next.ID {
+		t.Fatal("expected the promoted key to sign")
+	}
+
+	// A manual rotation discards the pre-published next key.
+	err = b.Ring(defaultKeyRing).Tick(testCtx, req, conf, current.Expires.Add(-30*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := b.Ring(defaultKeyRing).Rotate(testCtx, req, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining, err = readPrivateKey(testCtx, req, "nextkey/default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != nil {
+		t.Fatal("expected the next key to be discarded by the rotation")
+	}
+	err = b.Ring(defaultKeyRing).Tick(testCtx, req, conf, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err = b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.ID != rotated.ID {
+		t.Fatal("expected the rotated key to keep signing")
+	}
+}
+
+func TestTickGeneratesKeys(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := &backend{}
+	req := &logical.Request{Storage: storage}
+	conf := defaultConfig()
+	now := time.Now()
+
+	err := b.Ring(defaultKeyRing).Tick(testCtx, req, conf, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := readPrivateKey(testCtx, req, "privatekey/default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == nil {
+		t.Fatal("expected the periodic function to generate a signing key")
+	}
+
+	// The key is replaced before it expires.
+	err = b.Ring(defaultKeyRing).Tick(testCtx, req, conf, key.Expires.Add(-30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.ID == key.ID {
+		t.Fatal("expected the key to be rotated before it expires")
+	}
+
+}
+
+func TestAlgorithmChange(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := &backend{}
+	req := &logical.Request{Storage: storage}
+	conf := defaultConfig()
+
+	key, err := b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	assert(t, err)
+
+	// The current key keeps signing until the periodic function replaces it.
+	conf.Algorithm = "ES256"
+	current, err := b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	assert(t, err)
+	if current.ID != key.ID {
+		t.Fatal("expected the current key to sign until the periodic function runs")
+	}
+
+	// The periodic function publishes a key for the new algorithm, which
+	// only signs once it has been published for the lead time.
+	now := time.Now()
+	assert(t, b.Ring(defaultKeyRing).Tick(testCtx, req, conf, now))
+
+	next, err := readPrivateKey(testCtx, req, "nextkey/default")
+	assert(t, err)
+	if next == nil || next.Algorithm != "ES256" {
+		t.Fatalf("expected an ES256 next key but received %v", next)
+	}
+	published, err := b.getKey(testCtx, req, next.ID)
+	assert(t, err)
+	if published == nil {
+		t.Fatal("expected the next key to be published")
+	}
+
+	lead := time.Duration(conf.NextKeyLeadTime) * time.Second
+	assert(t, b.Ring(defaultKeyRing).Tick(testCtx, req, conf, now.Add(lead-time.Minute)))
+
+	current, err = b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	assert(t, err)
+	if current.ID != key.ID {
+		t.Fatal("expected the current key to sign during the lead time")
+	}
+
+	assert(t, b.Ring(defaultKeyRing).Tick(testCtx, req, conf, now.Add(lead)))
+
+	current, err = b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	assert(t, err)
+	if current.ID != next.ID || current.Algorithm != "ES256" {
+		t.Fatalf("expected the ES256 key to sign but received %s %s", current.Algorithm, current.ID)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	sys := &logical.StaticSystemView{}
+	b := Backend(&logical.BackendConfig{System: sys})
+	assert(t, b.Setup(testCtx, &logical.BackendConfig{System: sys}))
+	req := &logical.Request{Storage: storage}
+	conf := defaultConfig()
+
+	key, err := b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another node rotates the key.
+	other := &backend{}
+	rotated, err := other.Ring(defaultKeyRing).Rotate(testCtx, req, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.ID != key.ID {
+		t.Fatal("expected the cached key to be used")
+	}
+
+	b.InvalidateKey(testCtx, "privatekey/default")
+
+	current, err = b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.ID != rotated.ID {
+		t.Fatal("expected the rotated key to be used after invalidation")
+	}
+
+	// A performance standby forwards requests that need to write keys.
+	sys.ReplicationStateVal = consts.ReplicationPerformanceStandby
+	b.InvalidateKey(testCtx, "privatekey/default")
+
+	err = storage.Delete(testCtx, "privatekey/default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	if err != logical.ErrReadOnly {
+		t.Fatalf("expected %v but received %v", logical.ErrReadOnly, err)
+	}
+}
+
+func TestRSABits(t *testing.T) {
+	b, storage := newTestBackend(t)
+
+	resp, err := b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      map[string]interface{}{"rsa_bits": 1024},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Fatalf("expected a 400 response but received %v", resp)
+	}
+
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data:      map[string]interface{}{"rsa_bits": 3072},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "role/foo",
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "sign/foo",
+		},
+	} {
+		req.Storage = storage
+		resp, err := b.HandleRequest(testCtx, req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatal(err, resp)
+		}
+	}
+
+	keys, err := storage.List(testCtx, "key/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 key but got %d", len(keys))
+	}
+
+	resp, err = b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "key/" + keys[0],
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err)
+	}
+	if resp.Data["key_size"] != 3072 {
+		t.Errorf("expected a 3072 bit key but received %v", resp.Data["key_size"])
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	for _, name := range supportedAlgorithms() {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			b, storage := newTestBackend(t)
+
+			for _, req := range []*logical.Request{
+				{
+					Operation: logical.UpdateOperation,
+					Path:      "config",
+					Data:      map[string]interface{}{"algorithm": name},
+				},
+				{
+					Operation: logical.CreateOperation,
+					Path:      "role/foo",
+				},
+			} {
+				req.Storage = storage
+				resp, err := b.HandleRequest(testCtx, req)
+				if err != nil || (resp != nil && resp.IsError()) {
+					t.Fatal(err, resp)
+				}
+			}
+
+			resp, err := b.HandleRequest(testCtx, &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "sign/foo",
+				Storage:   storage,
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatal(err)
+			}
+
+			token, err := jwt.Parse(resp.Data["token"].(string), func(token *jwt.Token) (interface{}, error) {
+				if token.Method.Alg() != name {
+					t.Errorf("expected alg %q but received %q", name, token.Method.Alg())
+				}
+
+				key, err := b.getKey(testCtx, &logical.Request{Storage: storage}, token.Header["kid"].(string))
+				if err != nil {
+					return nil, err
+				}
+
+				jwk, err := key.JWK(token.Header["kid"].(string))
+				if err != nil {
+					return nil, err
+				}
+				if jwk.Algorithm != name {
+					t.Errorf("expected JWK alg %q but received %q", name, jwk.Algorithm)
+				}
+
+				return key.PublicKey()
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !token.Valid {
+				t.Error("should be valid")
+			}
+		})
+	}
+}
+
+func TestKeyFormats(t *testing.T) {
+	b, storage := newTestBackend(t)
+	req := &logical.Request{Storage: storage}
+
+	key, err := b.Ring(defaultKeyRing).Get(testCtx, req, defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(format string) *logical.Response {
+		t.Helper()
+
+		resp, err := b.HandleRequest(testCtx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "key/" + key.ID,
+			Storage:   storage,
+			Data:      map[string]interface{}{"format": format},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	type equaler interface {
+		Equal(crypto.PublicKey) bool
+	}
+
+	for format, parse := range map[string]func([]byte) (interface{}, error){
+		"pkcs1": func(der []byte) (interface{}, error) { return x509.ParsePKCS1PublicKey(der) },
+		"pkix":  x509.ParsePKIXPublicKey,
+	} {
+		resp := read(format)
+		if resp == nil || resp.IsError() {
+			t.Fatalf("%s: unexpected response %v", format, resp)
+		}
+
+		block, _ := pem.Decode([]byte(resp.Data["public"].(string)))
+		if block == nil {
+			t.Fatalf("%s: expected a PEM block", format)
+		}
+
+		pub, err := parse(block.Bytes)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !pub.(equaler).Equal(key.prvKey.Public()) {
+			t.Errorf("%s: public key does not match", format)
+		}
+	}
+
+	resp := read("jwk")
+	if jwk, ok := resp.Data["public"].(*JSONWebKey); !ok || jwk.KeyID != key.ID || jwk.KeyType != "RSA" {
+		t.Errorf("unexpected JWK %v", resp.Data["public"])
+	}
+
+	resp = read("der")
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Errorf("expected a 400 response but received %v", resp)
+	}
+
+	// Keys have no certificate until one is uploaded.
+	resp = read("x509")
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Errorf("expected a 400 response but received %v", resp)
+	}
+}
+
+func TestThumbprintKeyIDs(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	b := &backend{}
+	req := &logical.Request{Storage: storage}
+	conf := defaultConfig()
+	conf.KeyIDFormat = "thumbprint"
+
+	key, err := b.Ring(defaultKeyRing).Get(testCtx, req, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, err := newJSONWebKey("", key.Algorithm, key.prvKey.Public())
+	assert(t, err)
+	thumbprint, err := jwk.Thumbprint()
+	assert(t, err)
+
+	if key.ID != thumbprint {
+		t.Fatalf("expected kid %q but received %q", thumbprint, key.ID)
+	}
+
+	published, err := b.getKey(testCtx, req, thumbprint)
+	assert(t, err)
+	if published == nil {
+		t.Fatal("expected the key to be published under its thumbprint")
+	}
+}
+
+func TestTidy(t *testing.T) {
+	b, storage := newTestBackend(t)
+	req := &logical.Request{Storage: storage}
+
+	key, err := b.Ring(defaultKeyRing).Get(testCtx, req, defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert(t, err)
+	expired := time.Now().Add(-time.Hour)
+	assert(t, writePublicKey(testCtx, req, "expired", "RS256", &rsaKey.PublicKey, nil, expired))
+
+	// The signing key must survive even if its public key looks expired.
+	assert(t, writePublicKey(testCtx, req, key.ID, key.Algorithm, key.prvKey.Public(), nil, expired))
+
+	resp, err := b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "tidy",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err, resp)
+	}
+
+	resp, err = b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "tidy/status",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil {
+		t.Fatal(err, resp)
+	}
+	if deleted := toJSON(t, resp.Data["deleted"]); deleted != `["expired"]` {
+		t.Errorf("unexpected deleted keys %s", deleted)
+	}
+	if errs := toJSON(t, resp.Data["errors"]); errs != `[]` {
+		t.Errorf("unexpected errors %s", errs)
+	}
+
+	// Periodic runs that have nothing to report don't replace the status.
+	assert(t, b.periodic(testCtx, req))
+
+	entry, err := storage.Get(testCtx, "tidy/status")
+	assert(t, err)
+	var status *TidyStatus
+	assert(t, entry.DecodeJSON(&status))
+	if toJSON(t, status.Deleted) != `["expired"]` {
+		t.Errorf("expected the status of the manual run to be kept but received %v", status.Deleted)
+	}
+
+	published, err := b.getKey(testCtx, req, key.ID)
+	assert(t, err)
+	if published == nil {
+		t.Fatal("expected the signing key to remain published")
+	}
+}
+
+func TestTokenExpiryRetention(t *testing.T) {
+	b, storage := newTestBackend(t)
+
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data:      map[string]interface{}{"max_ttl": "72h", "grace_period": "2h", "key_retention": "24h"},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "role/foo",
+			Data:      map[string]interface{}{"ttl": "72h"},
+		},
+	} {
+		req.Storage = storage
+		resp, err := b.HandleRequest(testCtx, req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatal(err, resp)
+		}
+	}
+
+	resp, err := b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "sign/foo",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err, resp)
+	}
+
+	expires := time.Unix(resp.Data["expires"].(int64), 0)
+	keyID := tokenHeader(t, resp.Data["token"].(string))["kid"].(string)
+
+	// The token outlives the key's retention, so signing writes the expiry
+	// right away.
+	key, err := b.getKey(testCtx, &logical.Request{Storage: storage}, keyID)
+	assert(t, err)
+
+	if key.TokensExpire.Before(expires) || key.TokensExpire.Sub(expires) > time.Hour {
+		t.Fatalf("expected tokens to expire at %v but recorded %v", expires, key.TokensExpire)
+	}
+	if key.TokensExpire.Truncate(time.Hour) != key.TokensExpire {
+		t.Fatalf("expected the recorded expiry to be rounded to the hour but received %v", key.TokensExpire)
+	}
+	if key.Expires.Before(key.TokensExpire.Add(2 * time.Hour)) {
+		t.Fatalf("expected the key to be retained for the grace period but it expires at %v", key.Expires)
+	}
+
+	// Once the key no longer signs it is retained until the grace period ends.
+	_, err = b.Ring(defaultKeyRing).Rotate(testCtx, &logical.Request{Storage: storage}, defaultConfig())
+	assert(t, err)
+
+	deleted, err := b.cleanExpiredPublicKeys(testCtx, &logical.Request{Storage: storage}, key.TokensExpire.Add(time.Hour))
+	assert(t, err)
+	if len(deleted) != 0 {
+		t.Fatalf("expected the key to be retained while tokens may be valid but deleted %v", deleted)
+	}
+
+	deleted, err = b.cleanExpiredPublicKeys(testCtx, &logical.Request{Storage: storage}, key.TokensExpire.Add(3*time.Hour))
+	assert(t, err)
+	if len(deleted) != 1 || deleted[0] != keyID {
+		t.Fatalf("expected the key to be deleted after the grace period but deleted %v", deleted)
+	}
+}
+
+func TestConcurrentTokenExpiry(t *testing.T) {
+	b, storage := newTestBackend(t)
+
+	req := &logical.Request{Storage: storage}
+	key, err := b.Ring(defaultKeyRing).Get(testCtx, req, defaultConfig())
+	assert(t, err)
+
+	now := time.Now()
+	latest := now.Add(48 * time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 48; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := b.recordTokenExpiry(testCtx, req, defaultConfig(), key.ID, now.Add(time.Duration(i+1)*time.Hour))
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Expiries within the key's retention are written by the periodic
+	// function.
+	pub, err := b.getKey(testCtx, req, key.ID)
+	assert(t, err)
+	if !pub.TokensExpire.IsZero() {
+		t.Fatalf("expected sign requests not to write the key but recorded %v", pub.TokensExpire)
+	}
+
+	assert(t, b.writeTokenExpiries(testCtx, req, defaultConfig()))
+
+	pub, err = b.getKey(testCtx, req, key.ID)
+	assert(t, err)
+	if pub.TokensExpire.Before(latest) {
+		t.Fatalf("expected tokens to expire at %v but recorded %v", latest, pub.TokensExpire)
+	}
+}
+
+func TestSignTTL(t *testing.T) {
+	b, storage := newTestBackend(t)
+
+	resp, err := b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/foo",
+		Storage:   storage,
+		Data:      map[string]interface{}{"ttl": "1h", "max_ttl": "2h"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err, resp)
+	}
+
+	for requested, expected := range map[string]int{
+		"":    3600,
+		"5m":  300,
+		"90m": 5400,
+		"3h":  7200,
+	} {
+		data := map[string]interface{}{}
+		if requested != "" {
+			data["ttl"] = requested
+		}
+
+		resp, err := b.HandleRequest(testCtx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "sign/foo",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatal(err, resp)
+		}
+
+		if resp.Data["ttl"] != expected {
+			t.Fatalf("expected a ttl of %d for %q but received %v", expected, requested, resp.Data["ttl"])
+		}
+		if ttl := resp.Data["expires"].(int64) - time.Now().Unix(); ttl > int64(expected) || ttl < int64(expected)-5 {
+			t.Fatalf("expected the token to expire in %ds but it expires in %ds", expected, ttl)
+		}
+	}
+}
+
+// failingEntityView fails every identity lookup.
+type failingEntityView struct {
+	logical.StaticSystemView
+}
+
+func (failingEntityView) EntityInfo(entityID string) (*logical.Entity, error) {
+	return nil, fmt.Errorf("entity %s unavailable", entityID)
+}
+
+func TestSignEntity(t *testing.T) {
+	conf := &logical.BackendConfig{System: failingEntityView{}}
+	b := Backend(conf)
+	assert(t, b.Setup(testCtx, conf))
+	storage := &logical.InmemStorage{}
+
+	for name, defaults := range map[string]string{
+		"plain":     `{"team":"ops"}`,
+		"templated": `{"sub":"{{identity.entity.id}}"}`,
+	} {
+		resp, err := b.HandleRequest(testCtx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/" + name,
+			Storage:   storage,
+			Data:      map[string]interface{}{"defaults": defaults},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatal(err, resp)
+		}
+	}
+
+	sign := func(role string) error {
+		resp, err := b.HandleRequest(testCtx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "sign/" + role,
+			Storage:   storage,
+			EntityID:  "entity",
+		})
+		if err == nil && resp != nil && resp.IsError() {
+			err = resp.Error()
+		}
+		return err
+	}
+
+	// Roles without identity templates don't look up the entity.
+	assert(t, sign("plain"))
+
+	if sign("templated") == nil {
+		t.Fatal("expected the entity lookup to fail")
+	}
+}
+
+func TestSignAudience(t *testing.T) {
+	b, storage := newTestBackend(t)
+
+	sign := func(audience string) *logical.Response {
+		t.Helper()
+
+		data := map[string]interface{}{}
+		if audience != "" {
+			data["audience"] = audience
+		}
+
+		resp, err := b.HandleRequest(testCtx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "sign/foo",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	audience := func(resp *logical.Response) interface{} {
+		t.Helper()
+
+		if resp == nil || resp.IsError() {
+			t.Fatalf("expected a token but received %v", resp)
+		}
+
+		claims := jwt.MapClaims{}
+		_, _, err := new(jwt.Parser).ParseUnverified(resp.Data["token"].(string), claims)
+		assert(t, err)
+		return claims["aud"]
+	}
+
+	resp, err := b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/foo",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"defaults":          `{"aud":"https://default.example.com"}`,
+			"allowed_audiences": "https://posts.example.com,https://users.example.com",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err, resp)
+	}
+
+	if aud := audience(sign("")); aud != "https://default.example.com" {
+		t.Fatalf("expected the default audience but received %v", aud)
+	}
+	if aud := audience(sign("https://posts.example.com")); aud != "https://posts.example.com" {
+		t.Fatalf("expected the requested audience but received %v", aud)
+	}
+	if aud := audience(sign("https://posts.example.com,https://users.example.com")); toJSON(t, aud) != `["https://posts.example.com","https://users.example.com"]` {
+		t.Fatalf("expected both requested audiences but received %v", aud)
+	}
+
+	resp = sign("https://admin.example.com")
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Fatalf("expected a 400 response but received %v", resp)
+	}
+}
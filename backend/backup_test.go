@@ -0,0 +1,145 @@
+package backend
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestBackupRestore(t *testing.T) {
+	request := func(b *backend, storage logical.Storage, op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+
+		resp, err := b.HandleRequest(testCtx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	assert(t, err)
+	key := base64.StdEncoding.EncodeToString(buf)
+
+	src, srcStorage := newTestBackend(t)
+
+	resp := request(src, srcStorage, logical.UpdateOperation, "backup", map[string]interface{}{"key": key})
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Fatalf("expected backups to be disabled but received %v", resp)
+	}
+
+	request(src, srcStorage, logical.UpdateOperation, "config", map[string]interface{}{"allow_backup": true})
+	request(src, srcStorage, logical.CreateOperation, "role/foo", nil)
+	token := request(src, srcStorage, logical.CreateOperation, "sign/foo", nil).Data["token"].(string)
+	keyID := tokenHeader(t, token)["kid"]
+
+	resp = request(src, srcStorage, logical.UpdateOperation, "backup", map[string]interface{}{"key": key})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	backup := resp.Data["backup"].(string)
+
+	dst, dstStorage := newTestBackend(t)
+	request(dst, dstStorage, logical.UpdateOperation, "config", map[string]interface{}{"allow_backup": true})
+	request(dst, dstStorage, logical.CreateOperation, "role/foo", nil)
+
+	buf[0] ^= 0xff
+	resp = request(dst, dstStorage, logical.UpdateOperation, "restore", map[string]interface{}{
+		"key":    base64.StdEncoding.EncodeToString(buf),
+		"backup": backup,
+	})
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Fatalf("expected a 400 response for the wrong key but received %v", resp)
+	}
+
+	resp = request(dst, dstStorage, logical.UpdateOperation, "restore", map[string]interface{}{
+		"key":    key,
+		"backup": backup,
+	})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response %v", resp)
+	}
+
+	token = request(dst, dstStorage, logical.CreateOperation, "sign/foo", nil).Data["token"].(string)
+	if restored := tokenHeader(t, token)["kid"]; restored != keyID {
+		t.Fatalf("expected the restored key %v to sign but used %v", keyID, restored)
+	}
+
+	// Restoring the backup after a revocation does not bring the key back.
+	request(src, srcStorage, logical.UpdateOperation, "key/"+keyID.(string)+"/revoke", nil)
+	token = request(src, srcStorage, logical.CreateOperation, "sign/foo", nil).Data["token"].(string)
+	newKeyID := tokenHeader(t, token)["kid"]
+
+	resp = request(src, srcStorage, logical.UpdateOperation, "restore", map[string]interface{}{
+		"key":    key,
+		"backup": backup,
+	})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	if len(resp.Data["skipped"].([]string)) == 0 {
+		t.Fatalf("expected the revoked key to be skipped but received %v", resp.Data)
+	}
+
+	token = request(src, srcStorage, logical.CreateOperation, "sign/foo", nil).Data["token"].(string)
+	if kid := tokenHeader(t, token)["kid"]; kid != newKeyID {
+		t.Fatalf("expected %v to sign after the restore but used %v", newKeyID, kid)
+	}
+	pub, err := src.getKey(testCtx, &logical.Request{Storage: srcStorage}, keyID.(string))
+	assert(t, err)
+	if pub != nil {
+		t.Fatal("expected the revoked key to stay unpublished")
+	}
+
+	// A backup with an invalid entry is not restored at all.
+	buf[0] ^= 0xff
+	block, err := aes.NewCipher(buf)
+	assert(t, err)
+	aead, err := cipher.NewGCM(block)
+	assert(t, err)
+	seal := func(backup *Backup) string {
+		t.Helper()
+
+		plaintext, err := json.Marshal(backup)
+		assert(t, err)
+		nonce := make([]byte, aead.NonceSize())
+		blob := aead.Seal(append([]byte{backupVersion}, nonce...), nonce, plaintext, []byte{backupVersion})
+		return base64.StdEncoding.EncodeToString(blob)
+	}
+
+	for _, backup := range []*Backup{
+		{Entries: map[string][]byte{
+			"keys/partial":   []byte(`{}`),
+			"privatekey/bad": []byte(`not json`),
+		}},
+		{Entries: map[string][]byte{
+			"keys/partial":  []byte(`{}`),
+			"keys/nested/x": []byte(`{}`),
+		}},
+		nil,
+	} {
+		resp = request(dst, dstStorage, logical.UpdateOperation, "restore", map[string]interface{}{
+			"key":    key,
+			"backup": seal(backup),
+		})
+		if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+			t.Fatalf("expected a 400 response for an invalid backup but received %v", resp)
+		}
+		entry, err := dstStorage.Get(testCtx, "keys/partial")
+		assert(t, err)
+		if entry != nil {
+			t.Fatal("expected no entries of an invalid backup to be written")
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"time"
+)
+
+// noExpiry is the RFC 5280 notAfter value for certificates without a
+// well-defined expiration date. Keys are retired by unpublishing them, not by
+// certificate expiry.
+var noExpiry = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// selfSignedCertificate returns a DER encoded self-signed certificate for a
+// signing key.
+func selfSignedCertificate(keyID string, signer crypto.Signer) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: keyID},
+		NotBefore:             time.Now().Add(-5 * time.Minute),
+		NotAfter:              noExpiry,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+
+	return x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+}
+
+// certificateThumbprint returns the base64url encoded SHA-256 digest of a DER
+// encoded certificate as used by the x5t#S256 JWK member and JWT header.
+func certificateThumbprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// certificateRequest returns a DER encoded certificate signing request for a
+// signing key.
+func certificateRequest(commonName string, signer crypto.Signer) ([]byte, error) {
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}
+
+	return x509.CreateCertificateRequest(rand.Reader, template, signer)
+}
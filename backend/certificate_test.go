@@ -0,0 +1,163 @@
+package backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestKeyCertificate(t *testing.T) {
+	b, storage := newTestBackend(t)
+
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		t.Helper()
+
+		resp, err := b.HandleRequest(testCtx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatal(err, resp)
+		}
+		return resp
+	}
+
+	jwkFor := func(keyID string) *JSONWebKey {
+		t.Helper()
+
+		jwks, err := b.getJWKS(testCtx, &logical.Request{Storage: storage}, time.Now())
+		assert(t, err)
+		for _, jwk := range jwks.Keys {
+			if jwk.KeyID == keyID {
+				return jwk
+			}
+		}
+		t.Fatalf("key %q is not published", keyID)
+		return nil
+	}
+
+	request(logical.CreateOperation, "role/foo", map[string]interface{}{"include_x5t": true})
+
+	key, err := b.Ring(defaultKeyRing).Get(testCtx, &logical.Request{Storage: storage}, defaultConfig())
+	assert(t, err)
+	keyID := key.ID
+
+	jwk := jwkFor(keyID)
+	if len(jwk.CertificateChain) != 0 || jwk.CertificateThumbprint != "" {
+		t.Fatalf("expected no certificate before one is uploaded but received %v", jwk.CertificateChain)
+	}
+
+	resp, err := b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "sign/foo",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Fatalf("expected signing with x5t#S256 to fail without a certificate but received %v", resp)
+	}
+
+	// Issue a certificate from a CA.
+	csrPEM := request(logical.UpdateOperation, "key/"+keyID+"/csr", map[string]interface{}{
+		"common_name": "tokens.example.com",
+	}).Data["csr"].(string)
+
+	block, _ := pem.Decode([]byte(csrPEM))
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	assert(t, err)
+	assert(t, csr.CheckSignature())
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	assert(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	assert(t, err)
+
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      csr.Subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, ca, csr.PublicKey, caKey)
+	assert(t, err)
+
+	request(logical.UpdateOperation, "key/"+keyID+"/certificate", map[string]interface{}{
+		"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})) +
+			string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})),
+	})
+
+	jwk = jwkFor(keyID)
+	if len(jwk.CertificateChain) != 2 {
+		t.Fatalf("expected the certificate chain to be published but received %d", len(jwk.CertificateChain))
+	}
+	if jwk.CertificateThumbprint != certificateThumbprint(leafDER) {
+		t.Fatal("expected the thumbprint of the CA-issued certificate")
+	}
+
+	header := tokenHeader(t, request(logical.CreateOperation, "sign/foo", nil).Data["token"].(string))
+	if header["x5t#S256"] != certificateThumbprint(leafDER) {
+		t.Fatalf("expected the thumbprint of the CA-issued certificate in the header")
+	}
+
+	// A certificate for another key is rejected.
+	resp, err = b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "key/" + keyID + "/certificate",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Fatalf("expected a 400 response but received %v", resp)
+	}
+
+	// A key can be given a self-signed certificate.
+	request(logical.UpdateOperation, "key/"+keyID+"/certificate", map[string]interface{}{"self_signed": true})
+
+	jwk = jwkFor(keyID)
+	if len(jwk.CertificateChain) != 1 {
+		t.Fatalf("expected a self-signed certificate but received %d", len(jwk.CertificateChain))
+	}
+
+	// Keys generated with self_signed_certificates have a certificate right
+	// away, so roles with include_x5t keep signing after rotations.
+	request(logical.UpdateOperation, "config", map[string]interface{}{"self_signed_certificates": true})
+	keyID = request(logical.UpdateOperation, "rotate", nil).Data["name"].(string)
+
+	jwk = jwkFor(keyID)
+	if len(jwk.CertificateChain) != 1 {
+		t.Fatalf("expected a self-signed certificate but received %d", len(jwk.CertificateChain))
+	}
+
+	header = tokenHeader(t, request(logical.CreateOperation, "sign/foo", nil).Data["token"].(string))
+	if header["kid"] != keyID || header["x5t#S256"] != jwk.CertificateThumbprint {
+		t.Fatalf("expected the thumbprint of the self-signed certificate in the header but received %v", header)
+	}
+}
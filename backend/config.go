@@ -0,0 +1,118 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Issuer          string
+	Algorithm       string
+	RSABits         int
+	KeyTTL          int
+	NextKeyLeadTime int
+	KeyRetention    int
+	DefaultTTL      int
+	MaxTTL          int
+	KeyIDFormat     string
+	GracePeriod     int
+	AllowBackup     bool
+
+	// SelfSignedCertificates issues a self-signed certificate for every
+	// generated key.
+	SelfSignedCertificates bool
+
+	// RedactRevocationReason hides the reason of revocations from
+	// revoked/<kid>, which can be read without a token. It is set by
+	// default.
+	RedactRevocationReason bool
+}
+
+// minNextKeyLeadTime is the shortest lead time, in seconds, that spans two
+// runs of the periodic function.
+const minNextKeyLeadTime = int(2 * periodicInterval / time.Second)
+
+func defaultConfig() *Config {
+	return &Config{
+		Algorithm:       defaultAlgorithm,
+		RSABits:         defaultRSABits,
+		KeyTTL:          86400,      // 1d
+		NextKeyLeadTime: 3600,       // 1h
+		KeyRetention:    31 * 86400, // 31d
+		DefaultTTL:      3600,       // 1h
+		MaxTTL:          86400,      // 24h
+		KeyIDFormat:     "uuid",
+		GracePeriod:     3600, // 1h
+
+		RedactRevocationReason: true,
+	}
+}
+
+func (c *Config) Validate() error {
+	if _, err := getAlgorithm(c.Algorithm); err != nil {
+		return err
+	}
+	if !isValidRSABits(c.RSABits) {
+		return fmt.Errorf("rsa_bits must be one of %v", validRSABits)
+	}
+	// The next key must be published by a periodic run before the current
+	// key expires, so signing never waits for a new key.
+	if c.NextKeyLeadTime < minNextKeyLeadTime {
+		return fmt.Errorf("next_key_lead_time must be at least %ds", minNextKeyLeadTime)
+	}
+	if c.KeyTTL <= c.NextKeyLeadTime {
+		return errors.New("key_ttl must be greater than next_key_lead_time")
+	}
+	if c.DefaultTTL <= 0 {
+		return errors.New("default_ttl must be positive")
+	}
+	if c.MaxTTL < c.DefaultTTL {
+		return errors.New("max_ttl must not be less than default_ttl")
+	}
+	if c.KeyRetention < c.KeyTTL {
+		return errors.New("key_retention must be at least key_ttl")
+	}
+	if c.GracePeriod < 0 {
+		return errors.New("grace_period must not be negative")
+	}
+	if c.KeyIDFormat != "uuid" && c.KeyIDFormat != "thumbprint" {
+		return errors.New("key_id_format must be uuid or thumbprint")
+	}
+
+	if c.Issuer == "" {
+		return nil
+	}
+
+	u, err := url.Parse(c.Issuer)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return errors.New("issuer must be an http(s) URL")
+	}
+	if u.Host == "" {
+		return errors.New("issuer must have a host")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return errors.New("issuer must not have a query or fragment")
+	}
+
+	return nil
+}
+
+// JWKSURL returns the location of the JWKS relative to the issuer.
+func (c *Config) JWKSURL() string {
+	return strings.TrimSuffix(c.Issuer, "/") + "/.well-known/jwks.json"
+}
+
+func isValidRSABits(bits int) bool {
+	for _, b := range validRSABits {
+		if b == bits {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// privateJWK is the RFC 7517/7518/8037 representation of a private key.
+type privateJWK struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	D         string `json:"d"`
+	P         string `json:"p"`
+	Q         string `json:"q"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// parsePrivateKey parses a PKCS#1, PKCS#8 or SEC1 PEM encoded private key or
+// a private JWK. The key ID and algorithm are only set for JWKs that declare
+// them.
+func parsePrivateKey(data string) (signer crypto.Signer, keyID string, alg string, err error) {
+	data = strings.TrimSpace(data)
+
+	if strings.HasPrefix(data, "{") {
+		var jwk privateJWK
+		err = json.Unmarshal([]byte(data), &jwk)
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		signer, err = jwk.signer()
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		return signer, jwk.KeyID, jwk.Algorithm, nil
+	}
+
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, "", "", errors.New("private key must be PEM encoded or a JWK")
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	signer, _ = key.(crypto.Signer)
+	if signer == nil {
+		return nil, "", "", errors.New("unsupported private key type")
+	}
+
+	return signer, "", "", nil
+}
+
+func (j *privateJWK) signer() (crypto.Signer, error) {
+	if j.D == "" {
+		return nil, errors.New("JWK is not a private key")
+	}
+
+	switch j.KeyType {
+	case "RSA":
+		ints, err := decodeBigInts(j.N, j.E, j.D, j.P, j.Q)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: ints[0], E: int(ints[1].Int64())},
+			D:         ints[2],
+			Primes:    []*big.Int{ints[3], ints[4]},
+		}
+		err = key.Validate()
+		if err != nil {
+			return nil, err
+		}
+		key.Precompute()
+		return key, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Curve)
+		}
+
+		ints, err := decodeBigInts(j.X, j.Y, j.D)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: curve, X: ints[0], Y: ints[1]},
+			D:         ints[2],
+		}
+		x, y := curve.ScalarBaseMult(key.D.Bytes())
+		if x.Cmp(key.X) != 0 || y.Cmp(key.Y) != 0 {
+			return nil, errors.New("invalid EC private key")
+		}
+		return key, nil
+
+	case "OKP":
+		if j.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Curve)
+		}
+
+		seed, err := base64.RawURLEncoding.DecodeString(j.D)
+		if err != nil {
+			return nil, err
+		}
+		if len(seed) != ed25519.SeedSize {
+			return nil, errors.New("invalid Ed25519 private key")
+		}
+
+		key := ed25519.NewKeyFromSeed(seed)
+		if j.X != base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey)) {
+			return nil, errors.New("invalid Ed25519 private key")
+		}
+		return key, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.KeyType)
+	}
+}
+
+func decodeBigInts(values ...string) ([]*big.Int, error) {
+	ints := make([]*big.Int, len(values))
+	for i, v := range values {
+		if v == "" {
+			return nil, errors.New("JWK is missing required parameters")
+		}
+
+		n, err := decodeBigInt(v)
+		if err != nil {
+			return nil, err
+		}
+		ints[i] = n
+	}
+	return ints, nil
+}
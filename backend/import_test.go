@@ -0,0 +1,283 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+	// https://tools.ietf.org/html/rfc7638#section-3.1
+	jwk := &JSONWebKey{
+		KeyType: "RSA",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECP" +
+			"ebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2Qvzq" +
+			"Y368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0" +
+			"fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E: "AQAB",
+	}
+
+	thumbprint, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbprint != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("unexpected thumbprint %q", thumbprint)
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert(t, err)
+
+	encodePEM := func(typ string, der []byte) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}))
+	}
+	encodePKCS8 := func(key interface{}) string {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		assert(t, err)
+		return encodePEM("PRIVATE KEY", der)
+	}
+	encodeJWK := func(jwk map[string]interface{}) string {
+		data, err := json.Marshal(jwk)
+		assert(t, err)
+		return string(data)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	assert(t, err)
+
+	tests := []struct {
+		name  string
+		input string
+		key   crypto.Signer
+		alg   string
+	}{
+		{"pkcs1", encodePEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), rsaKey, "RS256"},
+		{"pkcs8 rsa", encodePKCS8(rsaKey), rsaKey, "RS256"},
+		{"sec1", encodePEM("EC PRIVATE KEY", ecDER), ecKey, "ES384"},
+		{"pkcs8 ec", encodePKCS8(ecKey), ecKey, "ES384"},
+		{"pkcs8 ed25519", encodePKCS8(edKey), edKey, "EdDSA"},
+		{"jwk rsa", encodeJWK(map[string]interface{}{
+			"kty": "RSA",
+			"n":   encodeBigInt(rsaKey.N),
+			"e":   "AQAB",
+			"d":   encodeBigInt(rsaKey.D),
+			"p":   encodeBigInt(rsaKey.Primes[0]),
+			"q":   encodeBigInt(rsaKey.Primes[1]),
+		}), rsaKey, "RS256"},
+		{"jwk ec", encodeJWK(map[string]interface{}{
+			"kty": "EC",
+			"crv": "P-384",
+			"x":   encodePaddedBigInt(ecKey.X, 48),
+			"y":   encodePaddedBigInt(ecKey.Y, 48),
+			"d":   encodePaddedBigInt(ecKey.D, 48),
+		}), ecKey, "ES384"},
+		{"jwk ed25519", encodeJWK(map[string]interface{}{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)),
+			"d":   base64.RawURLEncoding.EncodeToString(edKey.Seed()),
+		}), edKey, "EdDSA"},
+	}
+
+	for _, test := range tests {
+		signer, _, _, err := parsePrivateKey(test.input)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		type equaler interface {
+			Equal(crypto.PublicKey) bool
+		}
+		if !signer.Public().(equaler).Equal(test.key.Public()) {
+			t.Errorf("%s: parsed key does not match", test.name)
+		}
+
+		alg, err := algorithmFor(signer.Public())
+		if err != nil || alg != test.alg {
+			t.Errorf("%s: expected %s but received %s (%v)", test.name, test.alg, alg, err)
+		}
+	}
+
+	_, _, _, err = parsePrivateKey(encodeJWK(map[string]interface{}{
+		"kty": "RSA",
+		"n":   encodeBigInt(rsaKey.N),
+		"e":   "AQAB",
+	}))
+	if err == nil {
+		t.Error("expected public JWKs to be rejected")
+	}
+}
+
+func TestImportKey(t *testing.T) {
+	b, storage := newTestBackend(t)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	assert(t, err)
+
+	resp, err := b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "import",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err, resp)
+	}
+
+	jwk, err := newJSONWebKey("", "ES256", ecKey.Public())
+	assert(t, err)
+	thumbprint, err := jwk.Thumbprint()
+	assert(t, err)
+
+	if resp.Data["name"] != thumbprint {
+		t.Fatalf("expected kid %q but received %q", thumbprint, resp.Data["name"])
+	}
+	if resp.Data["algorithm"] != "ES256" {
+		t.Fatalf("expected ES256 but received %q", resp.Data["algorithm"])
+	}
+
+	algs, err := b.signingAlgorithms(testCtx, &logical.Request{Storage: storage}, defaultConfig())
+	assert(t, err)
+	if toJSON(t, algs) != `["RS256","ES256"]` {
+		t.Fatalf("expected the algorithm of the imported key to be advertised but received %v", algs)
+	}
+
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.CreateOperation,
+			Path:      "role/imported",
+			Data:      map[string]interface{}{"signing_key": thumbprint},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "role/generated",
+		},
+	} {
+		req.Storage = storage
+		resp, err := b.HandleRequest(testCtx, req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatal(err, resp)
+		}
+	}
+
+	resp, err = b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/missing",
+		Storage:   storage,
+		Data:      map[string]interface{}{"signing_key": "missing"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Fatalf("expected a 400 response but received %v", resp)
+	}
+
+	for role, expected := range map[string]string{"imported": thumbprint, "generated": ""} {
+		resp, err = b.HandleRequest(testCtx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "sign/" + role,
+			Storage:   storage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatal(err)
+		}
+
+		kid := tokenHeader(t, resp.Data["token"].(string))["kid"]
+		if expected != "" && kid != expected {
+			t.Errorf("expected role %s to sign with %q but used %q", role, expected, kid)
+		}
+		if expected == "" && kid == thumbprint {
+			t.Errorf("expected role %s not to sign with the imported key", role)
+		}
+	}
+
+	// The imported key can not be deleted while a role signs with it.
+	deleteKey := func() *logical.Response {
+		t.Helper()
+
+		resp, err := b.HandleRequest(testCtx, &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "import/" + thumbprint,
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp = deleteKey()
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Fatalf("expected a 400 response but received %v", resp)
+	}
+
+	resp, err = b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "role/imported",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err, resp)
+	}
+
+	if resp = deleteKey(); resp != nil && resp.IsError() {
+		t.Fatalf("unexpected response %v", resp)
+	}
+
+	// Deleting the key drops it from the cache.
+	if _, err = b.getImportedKey(testCtx, &logical.Request{Storage: storage}, thumbprint); err == nil {
+		t.Fatal("expected the deleted key to be gone")
+	}
+
+	// RSA keys must be at least as large as the smallest rsa_bits.
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert(t, err)
+	resp, err = b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "import",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Fatalf("expected a 400 response but received %v", resp)
+	}
+}
+
+func tokenHeader(t testing.TB, token string) map[string]interface{} {
+	t.Helper()
+
+	var header map[string]interface{}
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.SplitN(token, ".", 2)[0])
+	assert(t, err)
+	assert(t, json.Unmarshal(data, &header))
+
+	return header
+}
@@ -0,0 +1,176 @@
+package backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+)
+
+// JSONWebKey is the RFC 7517 representation of a public signing key.
+type JSONWebKey struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+
+	CertificateChain      []string `json:"x5c,omitempty"`
+	CertificateThumbprint string   `json:"x5t#S256,omitempty"`
+}
+
+// JSONWebKeySet is the RFC 7517 representation of a set of public keys.
+type JSONWebKeySet struct {
+	Keys []*JSONWebKey `json:"keys"`
+}
+
+// PublicKey decodes the stored public key.
+func (k *Key) PublicKey() (interface{}, error) {
+	block, _ := pem.Decode(k.PublicPEM)
+	if block == nil {
+		return nil, errors.New("invalid public key")
+	}
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, errors.New("invalid public key")
+	}
+}
+
+// JWK returns the public key as a JSON Web Key.
+func (k *Key) JWK(keyID string) (*JSONWebKey, error) {
+	pub, err := k.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	jwk, err := newJSONWebKey(keyID, k.algorithm(), pub)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(k.Certificate) > 0 {
+		for _, der := range append([][]byte{k.Certificate}, k.CertificateChain...) {
+			jwk.CertificateChain = append(jwk.CertificateChain, base64.StdEncoding.EncodeToString(der))
+		}
+		jwk.CertificateThumbprint = certificateThumbprint(k.Certificate)
+	}
+
+	return jwk, nil
+}
+
+func newJSONWebKey(keyID string, algorithm string, pub interface{}) (*JSONWebKey, error) {
+	jwk := &JSONWebKey{
+		KeyID:     keyID,
+		Algorithm: algorithm,
+		Use:       "sig",
+	}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encodeBigInt(pub.N)
+		jwk.E = encodeBigInt(big.NewInt(int64(pub.E)))
+
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = encodePaddedBigInt(pub.X, size)
+		jwk.Y = encodePaddedBigInt(pub.Y, size)
+
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+
+	default:
+		return nil, errors.New("unsupported public key type")
+	}
+
+	return jwk, nil
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the key.
+func (j *JSONWebKey) Thumbprint() (string, error) {
+	var members interface{}
+
+	// members must be in lexicographic order
+	switch j.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.KeyType, j.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{j.Curve, j.KeyType, j.X, j.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Curve, j.KeyType, j.X}
+	default:
+		return "", errors.New("unsupported key type")
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// spkiFingerprint returns the hex encoded SHA-256 digest of the DER encoded
+// SubjectPublicKeyInfo of a public key.
+func spkiFingerprint(pub interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func encodePaddedBigInt(i *big.Int, size int) string {
+	buf := make([]byte, size)
+	b := i.Bytes()
+	copy(buf[size-len(b):], b)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -2,39 +2,59 @@ package backend
 
 import (
 	"context"
-	"crypto/rand"
+	"crypto"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"path"
 	"sync"
 	"time"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/helper/locksutil"
 	"github.com/hashicorp/vault/logical"
 )
 
+// periodicInterval is the interval at which Vault invokes the periodic
+// function of a backend.
+const periodicInterval = time.Minute
+
+// currentKey holds the signing key of a single key ring.
 type currentKey struct {
+	ring   string
 	mtx    sync.RWMutex
 	prvKey *PrivateKey
+
+	// readOnly reports whether storage can not be written on this node, in
+	// which case requests that need to write keys are forwarded to the
+	// active node.
+	readOnly func() bool
 }
 
 type PrivateKey struct {
-	ID      string
-	Expires time.Time
-	DER     []byte
+	ID        string
+	Algorithm string
+	Activates time.Time
+	Expires   time.Time
+	DER       []byte
 
 	mtx    sync.RWMutex
-	prvKey *rsa.PrivateKey
+	prvKey crypto.Signer
 }
 
-func (c *currentKey) Get(ctx context.Context, req *logical.Request) (*PrivateKey, error) {
+func (c *currentKey) Get(ctx context.Context, req *logical.Request, conf *Config) (*PrivateKey, error) {
+	now := time.Now()
+
+	// A key that has not expired keeps signing after the algorithm or key
+	// size changes until the periodic function promotes its successor.
 	c.mtx.RLock()
 	key := c.prvKey
 	c.mtx.RUnlock()
 
-	if key != nil && key.Expires.After(time.Now()) {
+	if key.active(now) {
 		return decodePrivateKey(key, nil)
 	}
 
@@ -42,67 +62,388 @@ func (c *currentKey) Get(ctx context.Context, req *logical.Request) (*PrivateKey
 	defer c.mtx.Unlock()
 
 	key = c.prvKey
-	if key != nil && key.Expires.After(time.Now()) {
+	if key.active(now) {
+		return decodePrivateKey(key, nil)
+	}
+
+	key, err := c.readKey(ctx, req, "privatekey")
+	if err != nil {
+		return nil, err
+	}
+	if key.active(now) {
+		c.prvKey = key
 		return decodePrivateKey(key, nil)
 	}
 
-	entry, err := req.Storage.Get(ctx, "privatekey")
+	if c.isReadOnly() {
+		return nil, logical.ErrReadOnly
+	}
+
+	next, err := c.readKey(ctx, req, "nextkey")
 	if err != nil {
 		return nil, err
 	}
-	if entry != nil {
-		err = entry.DecodeJSON(&key)
+	if next == nil {
+		if key != nil {
+			// Later keys are generated ahead of time by the periodic
+			// function.
+			return nil, fmt.Errorf("key ring %q has no usable signing key", c.ring)
+		}
+
+		// The first key of a new mount or key ring is generated right away
+		// so it can sign before the periodic function runs.
+		return c.rotate(ctx, req, conf)
+	}
+
+	err = c.promote(ctx, req, conf, next, now)
+	if err != nil {
+		return nil, err
+	}
+	return decodePrivateKey(next, nil)
+}
+
+// Rotate replaces the current signing key with a newly generated key. The
+// public key of the previous signing key remains published until it expires.
+// A pre-published next key is discarded so the periodic function does not
+// replace the new key with an older one.
+func (c *currentKey) Rotate(ctx context.Context, req *logical.Request, conf *Config) (*PrivateKey, error) {
+	if c.isReadOnly() {
+		return nil, logical.ErrReadOnly
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	err := c.deleteNextKey(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.rotate(ctx, req, conf)
+}
+
+// Tick generates and publishes the next signing key once the current key
+// enters its lead time and promotes it before the current key expires. It is
+// called from the periodic function so sign requests only ever generate the
+// first key of a key ring.
+func (c *currentKey) Tick(ctx context.Context, req *logical.Request, conf *Config, now time.Time) error {
+	key, err := c.readKey(ctx, req, "privatekey")
+	if err != nil {
+		return err
+	}
+
+	next, err := c.readKey(ctx, req, "nextkey")
+	if err != nil {
+		return err
+	}
+	if !next.matches(conf) {
+		next = nil
+	}
+
+	// Keys are generated without holding c.mtx so concurrent sign requests
+	// are not stalled.
+	generated := false
+	if next == nil {
+		lead := time.Duration(conf.NextKeyLeadTime) * time.Second
+
+		switch {
+		case key == nil:
+			// There is no signing key yet; activate one now.
+			next, err = generatePrivateKey(ctx, req, conf, now)
+		case !key.matches(conf):
+			// The algorithm changed; the current key keeps signing while
+			// the new key is published for the lead time.
+			next, err = generatePrivateKey(ctx, req, conf, now.Add(lead))
+		case !now.Before(key.Expires.Add(-lead)):
+			next, err = generatePrivateKey(ctx, req, conf, key.Expires)
+		}
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if next == nil {
+			return nil
 		}
+		generated = true
+	}
+
+	// Promote the next key if the current key would expire before the
+	// following periodic run, or once a key for a new algorithm has been
+	// published for the lead time.
+	promote := !key.active(now.Add(periodicInterval)) ||
+		(!key.matches(conf) && !now.Before(next.Activates))
+	if !generated && !promote {
+		return nil
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	// A rotation or revocation may have replaced the keys since they were
+	// read; the next key only follows the key that was observed.
+	current, err := c.readKey(ctx, req, "privatekey")
+	if err != nil {
+		return err
+	}
+	if !current.sameKey(key) {
+		return nil
+	}
 
-		if key != nil && key.Expires.After(time.Now()) {
-			c.prvKey = key
-			return decodePrivateKey(key, nil)
+	if generated {
+		err = writePrivateKey(ctx, req, c.storagePath("nextkey"), next)
+		if err != nil {
+			return err
+		}
+	} else {
+		stored, err := c.readKey(ctx, req, "nextkey")
+		if err != nil {
+			return err
 		}
+		if !stored.sameKey(next) {
+			return nil
+		}
+	}
+
+	if !promote {
+		return nil
 	}
 
-	keyID, err := uuid.GenerateUUID()
+	return c.promote(ctx, req, conf, next, now)
+}
+
+// DiscardNextKey deletes the ring's next key if it is the key with keyID.
+func (c *currentKey) DiscardNextKey(ctx context.Context, req *logical.Request, keyID string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	next, err := c.readKey(ctx, req, "nextkey")
+	if err != nil {
+		return err
+	}
+	if next == nil || next.ID != keyID {
+		return nil
+	}
+
+	return c.deleteNextKey(ctx, req)
+}
+
+// Invalidate drops the cached signing key so it is reloaded from storage.
+func (c *currentKey) Invalidate(keyID string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if keyID == "" || (c.prvKey != nil && c.prvKey.ID == keyID) {
+		c.prvKey = nil
+	}
+}
+
+// storagePath returns the location of the ring's current ("privatekey") or
+// next ("nextkey") signing key.
+func (c *currentKey) storagePath(slot string) string {
+	return path.Join(slot, c.ring)
+}
+
+// readKey reads the ring's current or next signing key. Keys of the default
+// ring that were written before key rings existed are read from their legacy
+// location until the periodic function has migrated them.
+func (c *currentKey) readKey(ctx context.Context, req *logical.Request, slot string) (*PrivateKey, error) {
+	key, err := readPrivateKey(ctx, req, c.storagePath(slot))
+	if err != nil || key != nil || c.ring != defaultKeyRing {
+		return key, err
+	}
+
+	return readPrivateKey(ctx, req, slot)
+}
+
+func (c *currentKey) isReadOnly() bool {
+	return c.readOnly != nil && c.readOnly()
+}
+
+// rotate must be called with c.mtx held for writing.
+func (c *currentKey) rotate(ctx context.Context, req *logical.Request, conf *Config) (*PrivateKey, error) {
+	key, err := generatePrivateKey(ctx, req, conf, time.Now())
 	if err != nil {
 		return nil, err
 	}
 
-	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	err = writePrivateKey(ctx, req, c.storagePath("privatekey"), key)
 	if err != nil {
 		return nil, err
 	}
 
-	prvDer, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	c.prvKey = key
+	return key, nil
+}
+
+// promote makes next the current key; it must be called with c.mtx held for
+// writing.
+func (c *currentKey) promote(ctx context.Context, req *logical.Request, conf *Config, next *PrivateKey, now time.Time) error {
+	next.Expires = now.Add(time.Duration(conf.KeyTTL) * time.Second).UTC()
+
+	err := extendPublicKey(ctx, req, next.ID, now.Add(time.Duration(conf.KeyRetention)*time.Second))
+	if err != nil {
+		return err
+	}
+
+	err = writePrivateKey(ctx, req, c.storagePath("privatekey"), next)
+	if err != nil {
+		return err
+	}
+
+	err = c.deleteNextKey(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	c.prvKey = next
+	return nil
+}
+
+// deleteNextKey deletes the ring's next key; it must be called with c.mtx held
+// for writing.
+func (c *currentKey) deleteNextKey(ctx context.Context, req *logical.Request) error {
+	err := req.Storage.Delete(ctx, c.storagePath("nextkey"))
+	if err != nil {
+		return err
+	}
+	if c.ring == defaultKeyRing {
+		// the next key may not have been migrated yet
+		return req.Storage.Delete(ctx, "nextkey")
+	}
+
+	return nil
+}
+
+// generatePrivateKey generates a new key which becomes active at activates and
+// publishes its public key.
+func generatePrivateKey(ctx context.Context, req *logical.Request, conf *Config, activates time.Time) (*PrivateKey, error) {
+	alg, err := getAlgorithm(conf.Algorithm)
 	if err != nil {
 		return nil, err
 	}
 
-	now := time.Now()
-	key = &PrivateKey{
-		ID:      keyID,
-		Expires: now.AddDate(0, 0, 1).UTC(),
-		DER:     prvDer,
+	signer, err := alg.Generate(conf)
+	if err != nil {
+		return nil, err
+	}
 
-		prvKey: rsaKey,
+	keyID, err := newKeyID(conf, alg.Method.Alg(), signer.Public())
+	if err != nil {
+		return nil, err
 	}
 
-	entry, err = logical.StorageEntryJSON("privatekey", key)
+	prvDer, err := x509.MarshalPKCS8PrivateKey(signer)
 	if err != nil {
 		return nil, err
 	}
 
-	err = writePublicKey(ctx, req, keyID, &rsaKey.PublicKey, now.AddDate(0, 0, 31))
+	key := &PrivateKey{
+		ID:        keyID,
+		Algorithm: alg.Method.Alg(),
+		Activates: activates.UTC(),
+		Expires:   activates.Add(time.Duration(conf.KeyTTL) * time.Second).UTC(),
+		DER:       prvDer,
+
+		prvKey: signer,
+	}
+
+	var certificate []byte
+	if conf.SelfSignedCertificates {
+		certificate, err = selfSignedCertificate(keyID, signer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = writePublicKey(ctx, req, keyID, key.Algorithm, signer.Public(), certificate, activates.Add(time.Duration(conf.KeyRetention)*time.Second))
 	if err != nil {
 		return nil, err
 	}
 
-	err = req.Storage.Put(ctx, entry)
+	return key, nil
+}
+
+// newKeyID returns a random UUID or, when conf.KeyIDFormat is "thumbprint",
+// the RFC 7638 thumbprint of the key.
+func newKeyID(conf *Config, algorithm string, pub crypto.PublicKey) (string, error) {
+	if conf.KeyIDFormat != "thumbprint" {
+		return uuid.GenerateUUID()
+	}
+
+	jwk, err := newJSONWebKey("", algorithm, pub)
+	if err != nil {
+		return "", err
+	}
+
+	return jwk.Thumbprint()
+}
+
+func readPrivateKey(ctx context.Context, req *logical.Request, storagePath string) (*PrivateKey, error) {
+	entry, err := req.Storage.Get(ctx, storagePath)
 	if err != nil {
 		return nil, err
 	}
+	if entry == nil {
+		return nil, nil
+	}
 
-	c.prvKey = key
-	return decodePrivateKey(key, nil)
+	var key *PrivateKey
+
+	err = entry.DecodeJSON(&key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func writePrivateKey(ctx context.Context, req *logical.Request, storagePath string, key *PrivateKey) error {
+	entry, err := logical.StorageEntryJSON(storagePath, key)
+	if err != nil {
+		return err
+	}
+
+	return req.Storage.Put(ctx, entry)
+}
+
+// sameKey reports whether k and other are the same key or both nil.
+func (k *PrivateKey) sameKey(other *PrivateKey) bool {
+	if k == nil || other == nil {
+		return k == other
+	}
+	return k.ID == other.ID
+}
+
+// active reports whether the key can sign at now, regardless of the
+// configured algorithm.
+func (k *PrivateKey) active(now time.Time) bool {
+	return k != nil && k.Expires.After(now)
+}
+
+// matches reports whether the key was generated for the algorithm in conf.
+func (k *PrivateKey) matches(conf *Config) bool {
+	if k == nil {
+		return false
+	}
+
+	alg := k.Algorithm
+	if alg == "" {
+		alg = defaultAlgorithm
+	}
+	wanted := conf.Algorithm
+	if wanted == "" {
+		wanted = defaultAlgorithm
+	}
+
+	return alg == wanted
+}
+
+// SigningMethod returns the JWT signing method for the key.
+func (k *PrivateKey) SigningMethod() (jwt.SigningMethod, error) {
+	alg, err := getAlgorithm(k.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return alg.Method, nil
 }
 
 func decodePrivateKey(k *PrivateKey, e error) (*PrivateKey, error) {
@@ -143,7 +484,7 @@ func (k *PrivateKey) decodePrivateKey() error {
 		return err
 	}
 
-	prvKey, _ = prvKeyI.(*rsa.PrivateKey)
+	prvKey, _ = prvKeyI.(crypto.Signer)
 	if prvKey == nil {
 		return errors.New("invalid private key")
 	}
@@ -152,19 +493,39 @@ func (k *PrivateKey) decodePrivateKey() error {
 	return nil
 }
 
+// publicKeyLocks serialize the updates of the public keys at key/<kid>.
+var publicKeyLocks = locksutil.CreateLocks()
+
+// lockPublicKey locks the public key of a kid and returns the function that
+// unlocks it.
+func lockPublicKey(keyID string) func() {
+	lock := locksutil.LockForKey(publicKeyLocks, keyID)
+	lock.Lock()
+	return lock.Unlock
+}
+
+// writePublicKey publishes a public key, along with its DER encoded
+// certificate when it has one, until expires.
 func writePublicKey(
 	ctx context.Context, req *logical.Request,
-	keyID string, key *rsa.PublicKey, expires time.Time,
+	keyID string, algorithm string, key crypto.PublicKey, certificate []byte, expires time.Time,
 ) error {
 
+	publicPEM, err := encodePublicKey(key)
+	if err != nil {
+		return err
+	}
+
+	defer lockPublicKey(keyID)()
+
 	entry, err := logical.StorageEntryJSON(
 		path.Join("key", keyID),
 		&Key{
-			Expires: expires.UTC(),
-			PublicPEM: pem.EncodeToMemory(&pem.Block{
-				Type:  "RSA PUBLIC KEY",
-				Bytes: x509.MarshalPKCS1PublicKey(key),
-			}),
+			Algorithm:   algorithm,
+			Created:     time.Now().UTC(),
+			Expires:     expires.UTC(),
+			PublicPEM:   publicPEM,
+			Certificate: certificate,
 		})
 	if err != nil {
 		return err
@@ -177,3 +538,58 @@ func writePublicKey(
 
 	return nil
 }
+
+// extendPublicKey makes sure the public key remains published until at least
+// expires.
+func extendPublicKey(ctx context.Context, req *logical.Request, keyID string, expires time.Time) error {
+	defer lockPublicKey(keyID)()
+
+	entry, err := req.Storage.Get(ctx, path.Join("key", keyID))
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("public key %q not found", keyID)
+	}
+
+	var key *Key
+
+	err = entry.DecodeJSON(&key)
+	if err != nil {
+		return err
+	}
+
+	if !key.Expires.Before(expires) {
+		return nil
+	}
+
+	key.Expires = expires.UTC()
+
+	entry, err = logical.StorageEntryJSON(path.Join("key", keyID), key)
+	if err != nil {
+		return err
+	}
+
+	return req.Storage.Put(ctx, entry)
+}
+
+// encodePublicKey encodes RSA keys as PKCS#1 PEM (for compatibility with
+// existing verifiers) and all other keys as PKIX PEM.
+func encodePublicKey(key crypto.PublicKey) ([]byte, error) {
+	if rsaKey, ok := key.(*rsa.PublicKey); ok {
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PUBLIC KEY",
+			Bytes: x509.MarshalPKCS1PublicKey(rsaKey),
+		}), nil
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: der,
+	}), nil
+}
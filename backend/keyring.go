@@ -0,0 +1,210 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// defaultKeyRing is the key ring used by roles that don't select one. It
+// uses the key settings of the mount config unless they are overridden.
+const defaultKeyRing = "default"
+
+// KeyRing holds the key settings of a named key ring. Unset settings inherit
+// the mount config.
+type KeyRing struct {
+	Algorithm       string
+	RSABits         *int
+	KeyTTL          *int
+	NextKeyLeadTime *int
+	KeyRetention    *int
+}
+
+// Config returns conf with the key settings of the ring applied.
+func (r *KeyRing) Config(conf *Config) *Config {
+	c := *conf
+	if r == nil {
+		return &c
+	}
+
+	if r.Algorithm != "" {
+		c.Algorithm = r.Algorithm
+	}
+	if r.RSABits != nil {
+		c.RSABits = *r.RSABits
+	}
+	if r.KeyTTL != nil {
+		c.KeyTTL = *r.KeyTTL
+	}
+	if r.NextKeyLeadTime != nil {
+		c.NextKeyLeadTime = *r.NextKeyLeadTime
+	}
+	if r.KeyRetention != nil {
+		c.KeyRetention = *r.KeyRetention
+	}
+
+	return &c
+}
+
+// settings returns the settings of the ring by field name.
+func (r *KeyRing) settings() map[string]**int {
+	return map[string]**int{
+		"rsa_bits":           &r.RSABits,
+		"key_ttl":            &r.KeyTTL,
+		"next_key_lead_time": &r.NextKeyLeadTime,
+		"key_retention":      &r.KeyRetention,
+	}
+}
+
+// Inherited returns the names of the settings the ring inherits from the
+// mount config.
+func (r *KeyRing) Inherited() []string {
+	if r == nil {
+		r = &KeyRing{}
+	}
+
+	inherited := []string{}
+	if r.Algorithm == "" {
+		inherited = append(inherited, "algorithm")
+	}
+	for name, setting := range r.settings() {
+		if *setting == nil {
+			inherited = append(inherited, name)
+		}
+	}
+	sort.Strings(inherited)
+	return inherited
+}
+
+// keyRings holds the signing keys of all key rings.
+type keyRings struct {
+	mtx   sync.Mutex
+	rings map[string]*currentKey
+
+	readOnly func() bool
+}
+
+// Ring returns the signing key state of a key ring.
+func (k *keyRings) Ring(name string) *currentKey {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+
+	if k.rings == nil {
+		k.rings = make(map[string]*currentKey)
+	}
+
+	c := k.rings[name]
+	if c == nil {
+		c = &currentKey{ring: name, readOnly: k.readOnly}
+		k.rings[name] = c
+	}
+
+	return c
+}
+
+// invalidateKey drops a cached signing key from every key ring.
+func (k *keyRings) invalidateKey(keyID string) {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+
+	for _, c := range k.rings {
+		c.Invalidate(keyID)
+	}
+}
+
+// dropRing forgets the cached signing key of a deleted key ring.
+func (k *keyRings) dropRing(name string) {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+
+	delete(k.rings, name)
+}
+
+func (b *backend) getKeyRing(ctx context.Context, req *logical.Request, name string) (*KeyRing, error) {
+	entry, err := req.Storage.Get(ctx, path.Join("keys", name))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var ring *KeyRing
+
+	err = entry.DecodeJSON(&ring)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal failed: %v", err)
+	}
+
+	return ring, nil
+}
+
+// getKeyRingConfig returns the effective config of a key ring or nil if the
+// ring doesn't exist. The default ring always exists.
+func (b *backend) getKeyRingConfig(ctx context.Context, req *logical.Request, conf *Config, name string) (*Config, error) {
+	ring, err := b.getKeyRing(ctx, req, name)
+	if err != nil {
+		return nil, err
+	}
+	if ring == nil && name != defaultKeyRing {
+		return nil, nil
+	}
+
+	return ring.Config(conf), nil
+}
+
+// listKeyRings returns the names of all key rings.
+func (b *backend) listKeyRings(ctx context.Context, req *logical.Request) ([]string, error) {
+	names, err := req.Storage.List(ctx, "keys/")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if name == defaultKeyRing {
+			return names, nil
+		}
+	}
+
+	names = append(names, defaultKeyRing)
+	sort.Strings(names)
+	return names, nil
+}
+
+// migrateLegacyKeys moves the signing keys written before key rings existed
+// into the default ring.
+func (b *backend) migrateLegacyKeys(ctx context.Context, req *logical.Request) error {
+	for _, slot := range []string{"privatekey", "nextkey"} {
+		key, err := readPrivateKey(ctx, req, slot)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			continue
+		}
+
+		storagePath := path.Join(slot, defaultKeyRing)
+
+		existing, err := readPrivateKey(ctx, req, storagePath)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			err = writePrivateKey(ctx, req, storagePath, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = req.Storage.Delete(ctx, slot)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestKeyRings(t *testing.T) {
+	b, storage := newTestBackend(t)
+
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.CreateOperation,
+			Path:      "keys/tenant",
+			Data:      map[string]interface{}{"algorithm": "ES256", "key_ttl": 7200},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "role/tenant",
+			Data:      map[string]interface{}{"key_ring": "tenant"},
+		},
+		{
+			Operation: logical.CreateOperation,
+			Path:      "role/other",
+		},
+	} {
+		req.Storage = storage
+		resp, err := b.HandleRequest(testCtx, req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatal(err, resp)
+		}
+	}
+
+	resp, err := b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "keys/tenant",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil {
+		t.Fatal(err, resp)
+	}
+	if resp.Data["algorithm"] != "ES256" || resp.Data["key_ttl"] != 7200 {
+		t.Fatalf("unexpected key ring %v", resp.Data)
+	}
+	if resp.Data["key_retention"] != defaultConfig().KeyRetention {
+		t.Fatalf("expected the key ring to inherit key_retention but received %v", resp.Data["key_retention"])
+	}
+	if inherited := toJSON(t, resp.Data["inherited"]); inherited != `["key_retention","next_key_lead_time","rsa_bits"]` {
+		t.Fatalf("unexpected inherited settings %s", inherited)
+	}
+
+	resp, err = b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "keys/",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil {
+		t.Fatal(err, resp)
+	}
+	if rings := toJSON(t, resp.Data["keys"]); rings != `["default","tenant"]` {
+		t.Fatalf("unexpected key rings %s", rings)
+	}
+
+	for role, alg := range map[string]string{"tenant": "ES256", "other": "RS256"} {
+		resp, err = b.HandleRequest(testCtx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "sign/" + role,
+			Storage:   storage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatal(err, resp)
+		}
+
+		header := tokenHeader(t, resp.Data["token"].(string))
+		if header["alg"] != alg {
+			t.Errorf("expected role %s to sign with %s but used %v", role, alg, header["alg"])
+		}
+	}
+
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.CreateOperation,
+			Path:      "role/missing",
+			Data:      map[string]interface{}{"key_ring": "missing"},
+		},
+		{
+			Operation: logical.DeleteOperation,
+			Path:      "keys/tenant",
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "keys/tenant",
+			Data:      map[string]interface{}{"algorithm": "HS256"},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "keys/tenant",
+			Data:      map[string]interface{}{"next_key_lead_time": "1m"},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "keys/tenant",
+			Data:      map[string]interface{}{"key_ttl": "1h", "next_key_lead_time": "1h"},
+		},
+	} {
+		req.Storage = storage
+		resp, err := b.HandleRequest(testCtx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+			t.Fatalf("%s %s: expected a 400 response but received %v", req.Operation, req.Path, resp)
+		}
+	}
+}
+
+func TestKeyRingSettings(t *testing.T) {
+	b, storage := newTestBackend(t)
+
+	request := func(op logical.Operation, data map[string]interface{}) *logical.Response {
+		t.Helper()
+
+		resp, err := b.HandleRequest(testCtx, &logical.Request{
+			Operation: op,
+			Path:      "keys/tenant",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatal(err, resp)
+		}
+		return resp
+	}
+
+	request(logical.CreateOperation, map[string]interface{}{"next_key_lead_time": "2h"})
+	resp := request(logical.ReadOperation, nil)
+	if resp.Data["next_key_lead_time"] != 7200 {
+		t.Fatalf("expected next_key_lead_time 7200 but received %v", resp.Data["next_key_lead_time"])
+	}
+
+	request(logical.UpdateOperation, map[string]interface{}{"inherit": "next_key_lead_time"})
+	resp = request(logical.ReadOperation, nil)
+	if resp.Data["next_key_lead_time"] != defaultConfig().NextKeyLeadTime {
+		t.Fatalf("expected next_key_lead_time to be inherited but received %v", resp.Data["next_key_lead_time"])
+	}
+
+	// The mount config can't be changed so that a ring becomes invalid.
+	request(logical.UpdateOperation, map[string]interface{}{"key_ttl": "2h"})
+	resp, err := b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      map[string]interface{}{"key_ttl": "90m", "key_retention": "90m"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Fatalf("expected a 400 response but received %v", resp)
+	}
+}
+
+func TestMigrateLegacyKeys(t *testing.T) {
+	b, storage := newTestBackend(t)
+	req := &logical.Request{Storage: storage}
+
+	key, err := generatePrivateKey(testCtx, req, defaultConfig(), time.Now())
+	assert(t, err)
+	assert(t, writePrivateKey(testCtx, req, "privatekey", key))
+
+	// The legacy key signs until it is migrated.
+	current, err := b.Ring(defaultKeyRing).Get(testCtx, req, defaultConfig())
+	assert(t, err)
+	if current.ID != key.ID {
+		t.Fatal("expected the legacy key to be used")
+	}
+
+	assert(t, b.periodic(testCtx, req))
+
+	legacy, err := readPrivateKey(testCtx, req, "privatekey")
+	assert(t, err)
+	if legacy != nil {
+		t.Fatal("expected the legacy key to be removed")
+	}
+	migrated, err := readPrivateKey(testCtx, req, "privatekey/default")
+	assert(t, err)
+	if migrated == nil || migrated.ID != key.ID {
+		t.Fatal("expected the legacy key to be moved into the default ring")
+	}
+}
@@ -0,0 +1,292 @@
+package backend
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// backupVersion is the first byte of every backup.
+const backupVersion = 1
+
+// backupPrefixes are the storage prefixes included in a backup.
+var backupPrefixes = []string{"privatekey/", "nextkey/", "importedkey/", "key/", "keys/", "revoked/"}
+
+// backupEntries are the legacy storage entries included in a backup.
+var backupEntries = []string{"privatekey", "nextkey"}
+
+// Backup holds the key material of a mount.
+type Backup struct {
+	Created time.Time
+	Entries map[string][]byte
+}
+
+func backupPaths(b *backend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern:      "backup",
+			HelpSynopsis: ``,
+			Fields: map[string]*framework.FieldSchema{
+				"key": &framework.FieldSchema{Type: framework.TypeString},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathBackup,
+			},
+		},
+		&framework.Path{
+			Pattern:      "restore",
+			HelpSynopsis: ``,
+			Fields: map[string]*framework.FieldSchema{
+				"key":    &framework.FieldSchema{Type: framework.TypeString},
+				"backup": &framework.FieldSchema{Type: framework.TypeString},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathRestore,
+			},
+		},
+	}
+}
+
+// pathBackup exports all private and public keys encrypted with a caller
+// supplied AES-256 key.
+func (b *backend) pathBackup(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	aead, err := b.backupCipher(ctx, req, data)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	backup := &Backup{
+		Created: time.Now().UTC(),
+		Entries: map[string][]byte{},
+	}
+
+	storageKeys := append([]string{}, backupEntries...)
+	for _, prefix := range backupPrefixes {
+		keys, err := req.Storage.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			storageKeys = append(storageKeys, prefix+key)
+		}
+	}
+
+	for _, key := range storageKeys {
+		entry, err := req.Storage.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			backup.Entries[key] = entry.Value
+		}
+	}
+
+	plaintext, err := json.Marshal(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte{backupVersion}, nonce...)
+	blob = aead.Seal(blob, nonce, plaintext, []byte{backupVersion})
+
+	b.Logger().Info("created backup", "entries", len(backup.Entries))
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"backup":  base64.StdEncoding.EncodeToString(blob),
+			"created": backup.Created.Unix(),
+			"entries": len(backup.Entries),
+		},
+	}, nil
+}
+
+// pathRestore writes the keys of a backup to storage, replacing existing
+// entries with the same name.
+func (b *backend) pathRestore(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	aead, err := b.backupCipher(ctx, req, data)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(data.Get("backup").(string))
+	if err != nil {
+		return errorResponse(fmt.Errorf("invalid backup: %v", err))
+	}
+	if len(blob) < 1+aead.NonceSize() {
+		return errorResponse(errors.New("invalid backup"))
+	}
+	if blob[0] != backupVersion {
+		return errorResponse(fmt.Errorf("unsupported backup version %d", blob[0]))
+	}
+
+	nonce := blob[1 : 1+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, blob[1+aead.NonceSize():], blob[:1])
+	if err != nil {
+		return errorResponse(errors.New("backup can not be decrypted with this key"))
+	}
+
+	var backup *Backup
+
+	err = json.Unmarshal(plaintext, &backup)
+	if err != nil {
+		return errorResponse(fmt.Errorf("invalid backup: %v", err))
+	}
+	if backup == nil || backup.Entries == nil {
+		return errorResponse(errors.New("invalid backup: no entries"))
+	}
+
+	// Check every entry before writing any of them.
+	keyIDs := map[string]string{}
+	for key, value := range backup.Entries {
+		if !isBackupEntry(key) {
+			return errorResponse(fmt.Errorf("invalid backup entry %q", key))
+		}
+
+		keyID, err := backupEntryKeyID(key, value)
+		if err != nil {
+			return errorResponse(fmt.Errorf("invalid backup entry %q: %v", key, err))
+		}
+		keyIDs[key] = keyID
+	}
+
+	// Keys revoked since the backup was made are not restored.
+	skipped := []string{}
+	for key, keyID := range keyIDs {
+		if keyID == "" {
+			continue
+		}
+
+		revoked := backup.Entries[path.Join("revoked", keyID)] != nil
+		if !revoked {
+			revocation, err := b.getRevocation(ctx, req, keyID)
+			if err != nil {
+				return nil, err
+			}
+			revoked = revocation != nil
+		}
+
+		if revoked {
+			delete(backup.Entries, key)
+			skipped = append(skipped, key)
+		}
+	}
+	sort.Strings(skipped)
+
+	for key, value := range backup.Entries {
+		err = req.Storage.Put(ctx, &logical.StorageEntry{Key: key, Value: value})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b.invalidateKey("")
+	b.importedKeys.Invalidate("")
+	b.Logger().Info("restored backup", "entries", len(backup.Entries), "skipped", len(skipped), "created", backup.Created)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"created": backup.Created.Unix(),
+			"entries": len(backup.Entries),
+			"skipped": skipped,
+		},
+	}, nil
+}
+
+// backupEntryKeyID decodes a backup entry and returns the kid of the key it
+// holds, if any.
+func backupEntryKeyID(key string, value []byte) (string, error) {
+	entry := &logical.StorageEntry{Key: key, Value: value}
+
+	switch {
+	case strings.HasPrefix(key, "key/"):
+		var pub *Key
+		if err := entry.DecodeJSON(&pub); err != nil {
+			return "", err
+		}
+		if pub == nil {
+			return "", errors.New("empty public key")
+		}
+		return strings.TrimPrefix(key, "key/"), nil
+
+	case strings.HasPrefix(key, "keys/"):
+		var ring *KeyRing
+		return "", entry.DecodeJSON(&ring)
+
+	case strings.HasPrefix(key, "revoked/"):
+		var revocation *Revocation
+		return "", entry.DecodeJSON(&revocation)
+
+	default:
+		var prv *PrivateKey
+		if err := entry.DecodeJSON(&prv); err != nil {
+			return "", err
+		}
+		if prv == nil {
+			return "", errors.New("empty private key")
+		}
+		if err := prv.decodePrivateKey(); err != nil {
+			return "", err
+		}
+		return prv.ID, nil
+	}
+}
+
+// backupCipher returns the AES-GCM cipher for the key of a backup or restore
+// request once backups are allowed.
+func (b *backend) backupCipher(ctx context.Context, req *logical.Request, data *framework.FieldData) (cipher.AEAD, error) {
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if !conf.AllowBackup {
+		return nil, errors.New("backups are not allowed on this mount")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(data.Get("key").(string))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("key must be 32 base64 encoded bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// isBackupEntry reports whether key is a legacy entry or a single entry
+// directly below one of the backup prefixes.
+func isBackupEntry(key string) bool {
+	for _, entry := range backupEntries {
+		if key == entry {
+			return true
+		}
+	}
+	for _, prefix := range backupPrefixes {
+		name := strings.TrimPrefix(key, prefix)
+		if name != key && name != "" && !strings.Contains(name, "/") {
+			return true
+		}
+	}
+	return false
+}
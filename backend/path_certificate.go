@@ -0,0 +1,212 @@
+package backend
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func certificatePaths(b *backend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern:      "key/" + keyIDRegex("name") + "/csr",
+			HelpSynopsis: ``,
+			Fields: map[string]*framework.FieldSchema{
+				"name":        &framework.FieldSchema{Type: framework.TypeString},
+				"common_name": &framework.FieldSchema{Type: framework.TypeString},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathKeyCSR,
+			},
+		},
+		&framework.Path{
+			Pattern:      "key/" + keyIDRegex("name") + "/certificate",
+			HelpSynopsis: ``,
+			Fields: map[string]*framework.FieldSchema{
+				"name":        &framework.FieldSchema{Type: framework.TypeString},
+				"certificate": &framework.FieldSchema{Type: framework.TypeString},
+				"self_signed": &framework.FieldSchema{Type: framework.TypeBool},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathKeyCertificateWrite,
+			},
+		},
+	}
+}
+
+// pathKeyCSR returns a certificate signing request for a key so it can be
+// issued a certificate by a CA.
+func (b *backend) pathKeyCSR(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	keyID := data.Get("name").(string)
+
+	key, err := b.getPrivateKey(ctx, req, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return errorResponse(fmt.Errorf("no private key for %q", keyID))
+	}
+
+	commonName := data.Get("common_name").(string)
+	if commonName == "" {
+		commonName = keyID
+	}
+
+	der, err := certificateRequest(commonName, key.prvKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name": keyID,
+			"csr":  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})),
+		},
+	}, nil
+}
+
+// pathKeyCertificateWrite replaces the certificate of a key with a PEM
+// encoded certificate followed by its intermediate certificates, or with a
+// self-signed certificate when self_signed is set.
+func (b *backend) pathKeyCertificateWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	keyID := data.Get("name").(string)
+	certificate := data.Get("certificate").(string)
+	selfSigned := data.Get("self_signed").(bool)
+
+	if selfSigned && certificate != "" {
+		return errorResponse(errors.New("certificate and self_signed are mutually exclusive"))
+	}
+
+	if selfSigned {
+		prvKey, err := b.getPrivateKey(ctx, req, keyID)
+		if err != nil {
+			return nil, err
+		}
+		if prvKey == nil {
+			return errorResponse(fmt.Errorf("no private key for %q", keyID))
+		}
+
+		der, err := selfSignedCertificate(keyID, prvKey.prvKey)
+		if err != nil {
+			return nil, err
+		}
+		certificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	}
+
+	defer lockPublicKey(keyID)()
+
+	key, err := b.getKey(ctx, req, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return errorResponse(fmt.Errorf("no such key %q", keyID))
+	}
+
+	chain, err := parseCertificateChain(certificate)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	pub, err := key.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := chain[0]
+	leafKey, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !leafKey.Equal(pub) {
+		return errorResponse(errors.New("certificate does not match the key"))
+	}
+	if !time.Now().Before(leaf.NotAfter) {
+		return errorResponse(errors.New("certificate has expired"))
+	}
+
+	key.Certificate = leaf.Raw
+	key.CertificateChain = nil
+	for _, cert := range chain[1:] {
+		key.CertificateChain = append(key.CertificateChain, cert.Raw)
+	}
+
+	entry, err := logical.StorageEntryJSON(path.Join("key", keyID), key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = req.Storage.Put(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":       keyID,
+			"thumbprint": certificateThumbprint(key.Certificate),
+			"expires":    leaf.NotAfter.Unix(),
+		},
+	}, nil
+}
+
+// getPrivateKey returns the private key for a kid if it is an imported key or
+// the current or next key of a key ring.
+func (b *backend) getPrivateKey(ctx context.Context, req *logical.Request, keyID string) (*PrivateKey, error) {
+	key, err := readPrivateKey(ctx, req, path.Join("importedkey", keyID))
+	if err != nil || key != nil {
+		return decodePrivateKey(key, err)
+	}
+
+	rings, err := b.listKeyRings(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range rings {
+		for _, slot := range []string{"privatekey", "nextkey"} {
+			key, err := b.Ring(name).readKey(ctx, req, slot)
+			if err != nil {
+				return nil, err
+			}
+			if key != nil && key.ID == keyID {
+				return decodePrivateKey(key, nil)
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+func parseCertificateChain(data string) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, errors.New("certificate must be PEM encoded")
+	}
+
+	return chain, nil
+}
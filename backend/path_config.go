@@ -0,0 +1,258 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func configPaths(b *backend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern:      "config",
+			HelpSynopsis: ``,
+			Fields: map[string]*framework.FieldSchema{
+				"issuer":             &framework.FieldSchema{Type: framework.TypeString},
+				"algorithm":          &framework.FieldSchema{Type: framework.TypeString},
+				"rsa_bits":           &framework.FieldSchema{Type: framework.TypeInt},
+				"key_ttl":            &framework.FieldSchema{Type: framework.TypeDurationSecond},
+				"next_key_lead_time": &framework.FieldSchema{Type: framework.TypeDurationSecond},
+				"key_retention":      &framework.FieldSchema{Type: framework.TypeDurationSecond},
+				"default_ttl":        &framework.FieldSchema{Type: framework.TypeDurationSecond},
+				"max_ttl":            &framework.FieldSchema{Type: framework.TypeDurationSecond},
+				"key_id_format":      &framework.FieldSchema{Type: framework.TypeString},
+				"grace_period":       &framework.FieldSchema{Type: framework.TypeDurationSecond},
+				"allow_backup":       &framework.FieldSchema{Type: framework.TypeBool},
+
+				"redact_revocation_reason": &framework.FieldSchema{Type: framework.TypeBool},
+				"self_signed_certificates": &framework.FieldSchema{Type: framework.TypeBool},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   b.pathConfigRead,
+				logical.UpdateOperation: b.pathConfigUpdate,
+			},
+		},
+		&framework.Path{
+			Pattern:      `\.well-known/openid-configuration`,
+			HelpSynopsis: ``,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathDiscoveryRead,
+			},
+		},
+	}
+}
+
+func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"issuer":             conf.Issuer,
+			"algorithm":          conf.Algorithm,
+			"rsa_bits":           conf.RSABits,
+			"key_ttl":            conf.KeyTTL,
+			"next_key_lead_time": conf.NextKeyLeadTime,
+			"key_retention":      conf.KeyRetention,
+			"default_ttl":        conf.DefaultTTL,
+			"max_ttl":            conf.MaxTTL,
+			"key_id_format":      conf.KeyIDFormat,
+			"grace_period":       conf.GracePeriod,
+			"allow_backup":       conf.AllowBackup,
+
+			"redact_revocation_reason": conf.RedactRevocationReason,
+			"self_signed_certificates": conf.SelfSignedCertificates,
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if v, ok := data.GetOk("issuer"); ok {
+		conf.Issuer = strings.TrimSuffix(v.(string), "/")
+	}
+	if v, ok := data.GetOk("algorithm"); ok {
+		conf.Algorithm = v.(string)
+	}
+	if v, ok := data.GetOk("rsa_bits"); ok {
+		conf.RSABits = v.(int)
+	}
+	if v, ok := data.GetOk("key_ttl"); ok {
+		conf.KeyTTL = v.(int)
+	}
+	if v, ok := data.GetOk("next_key_lead_time"); ok {
+		conf.NextKeyLeadTime = v.(int)
+	}
+	if v, ok := data.GetOk("key_retention"); ok {
+		conf.KeyRetention = v.(int)
+	}
+	if v, ok := data.GetOk("default_ttl"); ok {
+		conf.DefaultTTL = v.(int)
+	}
+	if v, ok := data.GetOk("max_ttl"); ok {
+		conf.MaxTTL = v.(int)
+	}
+	if v, ok := data.GetOk("key_id_format"); ok {
+		conf.KeyIDFormat = v.(string)
+	}
+	if v, ok := data.GetOk("grace_period"); ok {
+		conf.GracePeriod = v.(int)
+	}
+	if v, ok := data.GetOk("allow_backup"); ok {
+		conf.AllowBackup = v.(bool)
+	}
+	if v, ok := data.GetOk("redact_revocation_reason"); ok {
+		conf.RedactRevocationReason = v.(bool)
+	}
+	if v, ok := data.GetOk("self_signed_certificates"); ok {
+		conf.SelfSignedCertificates = v.(bool)
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// Key rings inherit the settings they don't override and must remain
+	// valid under the new config.
+	rings, err := b.listKeyRings(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range rings {
+		ring, err := b.getKeyRing(ctx, req, name)
+		if err != nil {
+			return nil, err
+		}
+		if ring == nil {
+			continue
+		}
+
+		err = ring.Config(conf).Validate()
+		if err != nil {
+			return errorResponse(fmt.Errorf("key ring %s: %v", name, err))
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON("config", conf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = req.Storage.Put(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathDiscoveryRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Issuer == "" {
+		return nil, nil
+	}
+
+	algs, err := b.signingAlgorithms(ctx, req, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"issuer":                                conf.Issuer,
+		"jwks_uri":                              conf.JWKSURL(),
+		"response_types_supported":              []string{"id_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": algs,
+		"scopes_supported":                      []string{"openid"},
+		"claims_supported":                      []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "application/json",
+			logical.HTTPStatusCode:  200,
+			logical.HTTPRawBody:     string(body),
+		},
+	}, nil
+}
+
+// signingAlgorithms returns the algorithms used by the key rings and the
+// imported keys.
+func (b *backend) signingAlgorithms(ctx context.Context, req *logical.Request, conf *Config) ([]string, error) {
+	var algs []string
+	seen := map[string]bool{}
+	add := func(alg string) {
+		if !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+
+	rings, err := b.listKeyRings(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range rings {
+		ringConf, err := b.getKeyRingConfig(ctx, req, conf, name)
+		if err != nil {
+			return nil, err
+		}
+		if ringConf != nil {
+			add(ringConf.Algorithm)
+		}
+	}
+
+	// The algorithms of imported keys are read from their public keys so the
+	// unauthenticated discovery document never decodes private keys.
+	keyIDs, err := req.Storage.List(ctx, "importedkey/")
+	if err != nil {
+		return nil, err
+	}
+	for _, keyID := range keyIDs {
+		key, err := b.getKey(ctx, req, keyID)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			add(key.algorithm())
+		}
+	}
+
+	return algs, nil
+}
+
+func (b *backend) getConfig(ctx context.Context, req *logical.Request) (*Config, error) {
+	entry, err := req.Storage.Get(ctx, "config")
+	if err != nil {
+		return nil, err
+	}
+
+	conf := defaultConfig()
+	if entry == nil {
+		return conf, nil
+	}
+
+	err = entry.DecodeJSON(conf)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal failed: %v", err)
+	}
+
+	return conf, nil
+}
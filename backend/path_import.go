@@ -0,0 +1,285 @@
+package backend
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func importPaths(b *backend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern:      "import/?",
+			HelpSynopsis: ``,
+			Fields: map[string]*framework.FieldSchema{
+				"private_key": &framework.FieldSchema{Type: framework.TypeString},
+				"kid":         &framework.FieldSchema{Type: framework.TypeString},
+				"algorithm":   &framework.FieldSchema{Type: framework.TypeString},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation:   b.pathImportList,
+				logical.UpdateOperation: b.pathImportWrite,
+			},
+		},
+		&framework.Path{
+			Pattern:      "import/" + keyIDRegex("name"),
+			HelpSynopsis: ``,
+			Fields: map[string]*framework.FieldSchema{
+				"name": &framework.FieldSchema{Type: framework.TypeString},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   b.pathImportRead,
+				logical.DeleteOperation: b.pathImportDelete,
+			},
+		},
+	}
+}
+
+func (b *backend) pathImportList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	vals, err := req.Storage.List(ctx, "importedkey/")
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(vals), nil
+}
+
+func (b *backend) pathImportWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, keyID, algName, err := parsePrivateKey(data.Get("private_key").(string))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if v := data.Get("kid").(string); v != "" {
+		keyID = v
+	}
+	if v := data.Get("algorithm").(string); v != "" {
+		algName = v
+	}
+
+	if algName == "" {
+		algName, err = algorithmFor(signer.Public())
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	alg, err := getAlgorithm(algName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !alg.accepts(signer.Public()) {
+		return errorResponse(fmt.Errorf("algorithm %s can not be used with this key", algName))
+	}
+	if rsaKey, ok := signer.Public().(*rsa.PublicKey); ok && rsaKey.N.BitLen() < validRSABits[0] {
+		return errorResponse(fmt.Errorf("RSA keys must have at least %d bits", validRSABits[0]))
+	}
+
+	if keyID == "" {
+		jwk, err := newJSONWebKey("", algName, signer.Public())
+		if err != nil {
+			return nil, err
+		}
+
+		keyID, err = jwk.Thumbprint()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !keyIDRegexp.MatchString(keyID) {
+		return errorResponse(fmt.Errorf("invalid kid %q", keyID))
+	}
+
+	existing, err := b.getKey(ctx, req, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return errorResponse(fmt.Errorf("kid %q is already in use", keyID))
+	}
+
+	revocation, err := b.getRevocation(ctx, req, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if revocation != nil {
+		return errorResponse(fmt.Errorf("kid %q has been revoked", keyID))
+	}
+
+	prvDer, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &PrivateKey{
+		ID:        keyID,
+		Algorithm: alg.Method.Alg(),
+		DER:       prvDer,
+	}
+
+	now := time.Now()
+	err = writePublicKey(ctx, req, keyID, key.Algorithm, signer.Public(), nil, now.Add(time.Duration(conf.KeyRetention)*time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	err = writePrivateKey(ctx, req, path.Join("importedkey", keyID), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":      keyID,
+			"algorithm": key.Algorithm,
+		},
+	}, nil
+}
+
+func (b *backend) pathImportRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	key, err := readPrivateKey(ctx, req, path.Join("importedkey", data.Get("name").(string)))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":      key.ID,
+			"algorithm": key.Algorithm,
+		},
+	}, nil
+}
+
+func (b *backend) pathImportDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	keyID := data.Get("name").(string)
+
+	roleName, err := b.findRole(ctx, req, func(role *Role) bool {
+		return role.SigningKey == keyID
+	})
+	if err != nil {
+		return nil, err
+	}
+	if roleName != "" {
+		return errorResponse(fmt.Errorf("imported key %q is used by role %q", keyID, roleName))
+	}
+
+	err = req.Storage.Delete(ctx, path.Join("importedkey", keyID))
+	if err != nil {
+		return nil, err
+	}
+
+	b.importedKeys.Invalidate(keyID)
+
+	return nil, nil
+}
+
+// importedKeys caches the decoded imported keys so sign requests don't parse
+// them on every request.
+type importedKeys struct {
+	mtx  sync.RWMutex
+	keys map[string]*PrivateKey
+}
+
+// Invalidate drops a cached imported key, or all of them when keyID is empty,
+// so it is reloaded from storage.
+func (k *importedKeys) Invalidate(keyID string) {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+
+	if keyID == "" {
+		k.keys = nil
+		return
+	}
+	delete(k.keys, keyID)
+}
+
+// getImportedKey returns the imported private key used by a role.
+func (b *backend) getImportedKey(ctx context.Context, req *logical.Request, keyID string) (*PrivateKey, error) {
+	b.importedKeys.mtx.RLock()
+	key := b.importedKeys.keys[keyID]
+	b.importedKeys.mtx.RUnlock()
+
+	if key != nil {
+		return key, nil
+	}
+
+	b.importedKeys.mtx.Lock()
+	defer b.importedKeys.mtx.Unlock()
+
+	key = b.importedKeys.keys[keyID]
+	if key != nil {
+		return key, nil
+	}
+
+	key, err := decodePrivateKey(readPrivateKey(ctx, req, path.Join("importedkey", keyID)))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, errors.New("signing key not found")
+	}
+
+	if b.importedKeys.keys == nil {
+		b.importedKeys.keys = make(map[string]*PrivateKey)
+	}
+	b.importedKeys.keys[keyID] = key
+	return key, nil
+}
+
+// refreshImportedKeys keeps the public keys of imported keys published for
+// as long as the imported keys exist. Once an imported key is deleted its
+// public key expires after the retention window.
+func (b *backend) refreshImportedKeys(ctx context.Context, req *logical.Request, conf *Config, now time.Time) error {
+	keyIDs, err := req.Storage.List(ctx, "importedkey/")
+	if err != nil {
+		return err
+	}
+
+	retention := time.Duration(conf.KeyRetention) * time.Second
+	refresh := retention / 2
+
+	for _, keyID := range keyIDs {
+		key, err := b.getKey(ctx, req, keyID)
+		if err != nil {
+			return err
+		}
+		if key != nil && key.Expires.After(now.Add(retention-refresh)) {
+			continue
+		}
+		if key != nil {
+			err = extendPublicKey(ctx, req, keyID, now.Add(retention))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		// the public key is missing; publish it again
+		prvKey, err := b.getImportedKey(ctx, req, keyID)
+		if err != nil {
+			return err
+		}
+
+		err = writePublicKey(ctx, req, keyID, prvKey.Algorithm, prvKey.prvKey.Public(), nil, now.Add(retention))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func jwksPaths(b *backend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern:      "jwks",
+			HelpSynopsis: ``,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathJWKSRead,
+			},
+		},
+		&framework.Path{
+			Pattern:      `\.well-known/jwks\.json`,
+			HelpSynopsis: ``,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathJWKSRead,
+			},
+		},
+	}
+}
+
+func (b *backend) pathJWKSRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	jwks, err := b.getJWKS(ctx, req, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "application/json",
+			logical.HTTPStatusCode:  200,
+			logical.HTTPRawBody:     string(body),
+		},
+	}, nil
+}
+
+func (b *backend) getJWKS(ctx context.Context, req *logical.Request, now time.Time) (*JSONWebKeySet, error) {
+	keyIDs, err := req.Storage.List(ctx, "key/")
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := &JSONWebKeySet{Keys: []*JSONWebKey{}}
+
+	for _, keyID := range keyIDs {
+		key, err := b.getKey(ctx, req, keyID)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil || !now.Before(key.Expires) {
+			continue
+		}
+
+		jwk, err := key.JWK(keyID)
+		if err != nil {
+			return nil, err
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks, nil
+}
@@ -0,0 +1,214 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func keyRingPaths(b *backend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern:      "keys/?",
+			HelpSynopsis: ``,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.pathKeyRingList,
+			},
+		},
+		&framework.Path{
+			Pattern:      "keys/" + framework.GenericNameRegex("name"),
+			HelpSynopsis: ``,
+			Fields: map[string]*framework.FieldSchema{
+				"name":               &framework.FieldSchema{Type: framework.TypeNameString},
+				"algorithm":          &framework.FieldSchema{Type: framework.TypeString},
+				"rsa_bits":           &framework.FieldSchema{Type: framework.TypeInt},
+				"key_ttl":            &framework.FieldSchema{Type: framework.TypeDurationSecond},
+				"next_key_lead_time": &framework.FieldSchema{Type: framework.TypeDurationSecond},
+				"key_retention":      &framework.FieldSchema{Type: framework.TypeDurationSecond},
+				"inherit":            &framework.FieldSchema{Type: framework.TypeCommaStringSlice},
+			},
+			ExistenceCheck: b.pathKeyRingExistenceCheck,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   b.pathKeyRingRead,
+				logical.CreateOperation: b.pathKeyRingCreateUpdate,
+				logical.UpdateOperation: b.pathKeyRingCreateUpdate,
+				logical.DeleteOperation: b.pathKeyRingDelete,
+			},
+		},
+		&framework.Path{
+			Pattern:      "keys/" + framework.GenericNameRegex("name") + "/rotate",
+			HelpSynopsis: ``,
+			Fields: map[string]*framework.FieldSchema{
+				"name": &framework.FieldSchema{Type: framework.TypeNameString},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathKeyRingRotate,
+			},
+		},
+	}
+}
+
+func (b *backend) pathKeyRingList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	vals, err := b.listKeyRings(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(vals), nil
+}
+
+func (b *backend) pathKeyRingExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	out, err := req.Storage.Get(ctx, req.Path)
+	if err != nil {
+		return false, fmt.Errorf("existence check failed: %v", err)
+	}
+
+	return out != nil, nil
+}
+
+func (b *backend) pathKeyRingRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	name := data.Get("name").(string)
+	ringConf, err := b.getKeyRingConfig(ctx, req, conf, name)
+	if err != nil {
+		return nil, err
+	}
+	if ringConf == nil {
+		return nil, nil
+	}
+
+	ring, err := b.getKeyRing(ctx, req, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":               name,
+			"algorithm":          ringConf.Algorithm,
+			"rsa_bits":           ringConf.RSABits,
+			"key_ttl":            ringConf.KeyTTL,
+			"next_key_lead_time": ringConf.NextKeyLeadTime,
+			"key_retention":      ringConf.KeyRetention,
+			"inherited":          ring.Inherited(),
+		},
+	}, nil
+}
+
+func (b *backend) pathKeyRingCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	name := data.Get("name").(string)
+	ring, err := b.getKeyRing(ctx, req, name)
+	if err != nil {
+		return nil, err
+	}
+	if ring == nil {
+		ring = &KeyRing{}
+	}
+
+	if v, ok := data.GetOk("algorithm"); ok {
+		ring.Algorithm = v.(string)
+	}
+	settings := ring.settings()
+	for name, setting := range settings {
+		if v, ok := data.GetOk(name); ok {
+			value := v.(int)
+			*setting = &value
+		}
+	}
+
+	for _, name := range data.Get("inherit").([]string) {
+		if name == "algorithm" {
+			ring.Algorithm = ""
+			continue
+		}
+		setting, ok := settings[name]
+		if !ok {
+			return errorResponse(fmt.Errorf("unknown setting %q", name))
+		}
+		*setting = nil
+	}
+
+	err = ring.Config(conf).Validate()
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	entry, err := logical.StorageEntryJSON(path.Join("keys", name), ring)
+	if err != nil {
+		return nil, err
+	}
+
+	err = req.Storage.Put(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathKeyRingDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	if name == defaultKeyRing {
+		// the default ring falls back to the mount config
+		err := req.Storage.Delete(ctx, path.Join("keys", name))
+		if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	roleName, err := b.findRole(ctx, req, func(role *Role) bool {
+		return role.keyRing() == name
+	})
+	if err != nil {
+		return nil, err
+	}
+	if roleName != "" {
+		return errorResponse(fmt.Errorf("key ring %q is used by role %q", name, roleName))
+	}
+
+	// The public keys of the ring remain published until they expire.
+	for _, storagePath := range []string{
+		path.Join("keys", name),
+		path.Join("privatekey", name),
+		path.Join("nextkey", name),
+	} {
+		err = req.Storage.Delete(ctx, storagePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b.dropRing(name)
+	return nil, nil
+}
+
+func (b *backend) pathKeyRingRotate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	name := data.Get("name").(string)
+	ringConf, err := b.getKeyRingConfig(ctx, req, conf, name)
+	if err != nil {
+		return nil, err
+	}
+	if ringConf == nil {
+		return errorResponse(fmt.Errorf("no such key ring %q", name))
+	}
+
+	return b.rotateKeyRing(ctx, req, ringConf, name)
+}
@@ -2,34 +2,84 @@ package backend
 
 import (
 	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"path"
+	"regexp"
+	"sync"
 	"time"
 
+	multierror "github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
 
+// keyIDRegexp matches valid key IDs.
+var keyIDRegexp = regexp.MustCompile("^" + keyIDRegex("kid") + "$")
+
+// keyIDRegex returns a pattern for key IDs. Unlike GenericNameRegex it allows
+// leading and trailing dashes as they occur in base64url encoded thumbprints.
+func keyIDRegex(name string) string {
+	return fmt.Sprintf(`(?P<%s>[\w-]+)`, name)
+}
+
 type Key struct {
+	Algorithm string
+	Created   time.Time
 	Expires   time.Time
 	PublicPEM []byte
+
+	// TokensExpire is the latest expiry, rounded up to the hour, of the
+	// tokens signed with the key.
+	TokensExpire time.Time
+
+	Certificate []byte
+
+	// CertificateChain holds the intermediate certificates of a CA-issued
+	// certificate.
+	CertificateChain [][]byte
 }
 
 func keyPaths(b *backend) []*framework.Path {
 	return []*framework.Path{
 		&framework.Path{
-			Pattern:      "key/" + framework.GenericNameRegex("name"),
+			Pattern:      "key/?",
+			HelpSynopsis: ``,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.pathKeyList,
+			},
+		},
+		&framework.Path{
+			Pattern:      "key/" + keyIDRegex("name"),
 			HelpSynopsis: ``,
 			Fields: map[string]*framework.FieldSchema{
-				"name":   &framework.FieldSchema{Type: framework.TypeNameString},
-				"public": &framework.FieldSchema{Type: framework.TypeString},
+				"name":   &framework.FieldSchema{Type: framework.TypeString},
+				"format": &framework.FieldSchema{Type: framework.TypeString},
 			},
 			ExistenceCheck: b.pathKeyExistenceCheck,
 			Callbacks: map[logical.Operation]framework.OperationFunc{
 				logical.ReadOperation: b.pathKeyRead,
 			},
 		},
+		&framework.Path{
+			Pattern:      "rotate",
+			HelpSynopsis: ``,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathKeyRotate,
+			},
+		},
+	}
+}
+
+func (b *backend) pathKeyList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	vals, err := req.Storage.List(ctx, "key/")
+	if err != nil {
+		return nil, err
 	}
+	return logical.ListResponse(vals), nil
 }
 
 func (b *backend) pathKeyExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
@@ -50,14 +100,281 @@ func (b *backend) pathKeyRead(ctx context.Context, req *logical.Request, data *f
 		return nil, nil
 	}
 
+	keyID := data.Get("name").(string)
+
+	pub, err := key.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := spkiFingerprint(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	jwk, err := key.JWK(keyID)
+	if err != nil {
+		return nil, err
+	}
+	thumbprint, err := jwk.Thumbprint()
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := b.isActiveSigner(ctx, req, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := key.encode(jwk, data.Get("format").(string))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	var created, tokensExpire interface{}
+	if !key.Created.IsZero() {
+		created = key.Created.Unix()
+	}
+	if !key.TokensExpire.IsZero() {
+		tokensExpire = key.TokensExpire.Unix()
+	}
+
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"name":   data.Get("name").(string),
-			"public": string(key.PublicPEM),
+			"name":          keyID,
+			"algorithm":     key.algorithm(),
+			"key_size":      keySize(pub),
+			"public":        public,
+			"created":       created,
+			"expires":       key.Expires.Unix(),
+			"tokens_expire": tokensExpire,
+			"active":        active,
+			"spki_sha256":   fingerprint,
+			"thumbprint":    thumbprint,
 		},
 	}, nil
 }
 
+// isActiveSigner reports whether a key currently signs new tokens, either as
+// the current key of a key ring or as an imported key.
+func (b *backend) isActiveSigner(ctx context.Context, req *logical.Request, keyID string) (bool, error) {
+	rings, err := b.listKeyRings(ctx, req)
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range rings {
+		key, err := b.Ring(name).readKey(ctx, req, "privatekey")
+		if err != nil {
+			return false, err
+		}
+		if key != nil && key.ID == keyID {
+			return true, nil
+		}
+	}
+
+	imported, err := readPrivateKey(ctx, req, path.Join("importedkey", keyID))
+	if err != nil {
+		return false, err
+	}
+
+	return imported != nil, nil
+}
+
+func (b *backend) pathKeyRotate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	ringConf, err := b.getKeyRingConfig(ctx, req, conf, defaultKeyRing)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.rotateKeyRing(ctx, req, ringConf, defaultKeyRing)
+}
+
+func (b *backend) rotateKeyRing(ctx context.Context, req *logical.Request, conf *Config, name string) (*logical.Response, error) {
+	key, err := b.Ring(name).Rotate(ctx, req, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":      key.ID,
+			"algorithm": key.Algorithm,
+			"expires":   key.Expires.Unix(),
+		},
+	}, nil
+}
+
+func (k *Key) algorithm() string {
+	if k.Algorithm == "" {
+		return defaultAlgorithm
+	}
+	return k.Algorithm
+}
+
+// encode returns the public key in the requested format. Without a format the
+// stored PEM is returned.
+func (k *Key) encode(jwk *JSONWebKey, format string) (interface{}, error) {
+	switch format {
+	case "":
+		return string(k.PublicPEM), nil
+
+	case "pkcs1", "pkix":
+		pub, err := k.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+
+		if format == "pkix" {
+			der, err := x509.MarshalPKIXPublicKey(pub)
+			if err != nil {
+				return nil, err
+			}
+			return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+		}
+
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("pkcs1 is only supported for RSA keys")
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(rsaKey)})), nil
+
+	case "jwk":
+		return jwk, nil
+
+	case "x509":
+		if len(k.Certificate) == 0 {
+			return nil, errors.New("key has no certificate")
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: k.Certificate})), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// tokenExpiries holds the token expiries recorded by sign requests until the
+// periodic function writes them to the public keys.
+type tokenExpiries struct {
+	mtx     sync.Mutex
+	pending map[string]time.Time
+}
+
+// record remembers that a token signed with a key expires at expires.
+func (t *tokenExpiries) record(keyID string, expires time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.pending == nil {
+		t.pending = make(map[string]time.Time)
+	}
+	if t.pending[keyID].Before(expires) {
+		t.pending[keyID] = expires
+	}
+}
+
+// take returns and forgets the recorded expiries.
+func (t *tokenExpiries) take() map[string]time.Time {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	pending := t.pending
+	t.pending = nil
+	return pending
+}
+
+// get returns the recorded expiry of a key that has not been written yet.
+func (t *tokenExpiries) get(keyID string) time.Time {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return t.pending[keyID]
+}
+
+// recordTokenExpiry keeps a public key published until a token signed with
+// it has expired plus the grace period. The expiry is rounded up to the hour
+// so the key is updated at most once an hour. Usually the expiry is written
+// by the periodic function; only a token that would outlive the published
+// key extends it before it is signed, so the expiry can't be lost on a
+// restart. Standbys forward sign requests that change the expiry to the
+// active node.
+func (b *backend) recordTokenExpiry(ctx context.Context, req *logical.Request, conf *Config, keyID string, expires time.Time) error {
+	rounded := expires.Truncate(time.Hour)
+	if rounded.Before(expires) {
+		rounded = rounded.Add(time.Hour)
+	}
+
+	// TokensExpire only grows, so most signs return here.
+	key, err := b.getKey(ctx, req, keyID)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return fmt.Errorf("public key %q not found", keyID)
+	}
+	if !key.TokensExpire.Before(rounded) {
+		return nil
+	}
+
+	if b.storageReadOnly() {
+		return logical.ErrReadOnly
+	}
+
+	if key.Expires.Before(rounded.Add(time.Duration(conf.GracePeriod) * time.Second)) {
+		return b.writeTokenExpiry(ctx, req, conf, keyID, rounded)
+	}
+
+	b.tokenExpiries.record(keyID, rounded)
+	return nil
+}
+
+// writeTokenExpiries writes the token expiries recorded by sign requests to
+// the public keys. Expiries that can't be written are retried on the next
+// run.
+func (b *backend) writeTokenExpiries(ctx context.Context, req *logical.Request, conf *Config) error {
+	var result error
+
+	for keyID, expires := range b.tokenExpiries.take() {
+		err := b.writeTokenExpiry(ctx, req, conf, keyID, expires)
+		if err != nil {
+			b.tokenExpiries.record(keyID, expires)
+			result = multierror.Append(result, fmt.Errorf("key %s: %v", keyID, err))
+		}
+	}
+
+	return result
+}
+
+func (b *backend) writeTokenExpiry(ctx context.Context, req *logical.Request, conf *Config, keyID string, expires time.Time) error {
+	defer lockPublicKey(keyID)()
+
+	key, err := b.getKey(ctx, req, keyID)
+	if err != nil {
+		return err
+	}
+	if key == nil || !key.TokensExpire.Before(expires) {
+		// revoked, or already recorded
+		return nil
+	}
+
+	key.TokensExpire = expires.UTC()
+	if retain := expires.Add(time.Duration(conf.GracePeriod) * time.Second); key.Expires.Before(retain) {
+		key.Expires = retain.UTC()
+	}
+
+	entry, err := logical.StorageEntryJSON(path.Join("key", keyID), key)
+	if err != nil {
+		return err
+	}
+
+	return req.Storage.Put(ctx, entry)
+}
+
 func (b *backend) getKey(ctx context.Context, req *logical.Request, keyName string) (*Key, error) {
 	entry, err := req.Storage.Get(ctx, path.Join("key", keyName))
 	if err != nil {
@@ -77,37 +394,102 @@ func (b *backend) getKey(ctx context.Context, req *logical.Request, keyName stri
 	return key, nil
 }
 
-func (b *backend) cleanExpiredPublicKeys(ctx context.Context, req *logical.Request, now time.Time) error {
+// cleanExpiredPublicKeys deletes expired public keys that no longer sign
+// tokens. It continues past failures and returns the deleted kids along with
+// all errors.
+func (b *backend) cleanExpiredPublicKeys(ctx context.Context, req *logical.Request, now time.Time) ([]string, error) {
 	keys, err := req.Storage.List(ctx, "key/")
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	signers, err := b.signingKeyIDs(ctx, req)
+	if err != nil {
+		return nil, err
 	}
 
+	var (
+		result  error
+		deleted []string
+	)
+
 	for _, keyID := range keys {
-		err := b.cleanExpiredPublicKey(ctx, req, now, keyID)
+		if signers[keyID] {
+			// tokens signed with this key may still be issued
+			continue
+		}
+
+		ok, err := b.cleanExpiredPublicKey(ctx, req, now, keyID)
 		if err != nil {
-			return err
+			result = multierror.Append(result, fmt.Errorf("key %s: %v", keyID, err))
+			continue
+		}
+		if ok {
+			deleted = append(deleted, keyID)
 		}
 	}
 
-	return nil
+	return deleted, result
 }
 
-func (b *backend) cleanExpiredPublicKey(ctx context.Context, req *logical.Request, now time.Time, keyID string) error {
+func (b *backend) cleanExpiredPublicKey(ctx context.Context, req *logical.Request, now time.Time, keyID string) (bool, error) {
+	defer lockPublicKey(keyID)()
+
 	key, err := b.getKey(ctx, req, keyID)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if key == nil {
+		// deleted since it was listed
+		return false, nil
 	}
 
 	expires := key.Expires
 	if !expires.IsZero() && now.Before(expires) {
-		return nil
+		return false, nil
+	}
+	if now.Before(key.TokensExpire) || now.Before(b.tokenExpiries.get(keyID)) {
+		// tokens signed with this key have not yet expired
+		return false, nil
 	}
 
 	err = req.Storage.Delete(ctx, path.Join("key", keyID))
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	return true, nil
+}
+
+// signingKeyIDs returns the kids of all keys that sign or will sign tokens:
+// the current and next keys of every key ring and all imported keys.
+func (b *backend) signingKeyIDs(ctx context.Context, req *logical.Request) (map[string]bool, error) {
+	keyIDs, err := req.Storage.List(ctx, "importedkey/")
+	if err != nil {
+		return nil, err
+	}
+
+	signers := make(map[string]bool, len(keyIDs)+2)
+	for _, keyID := range keyIDs {
+		signers[keyID] = true
+	}
+
+	rings, err := b.listKeyRings(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range rings {
+		for _, slot := range []string{"privatekey", "nextkey"} {
+			key, err := b.Ring(name).readKey(ctx, req, slot)
+			if err != nil {
+				return nil, err
+			}
+			if key != nil {
+				signers[key.ID] = true
+			}
+		}
+	}
+
+	return signers, nil
 }
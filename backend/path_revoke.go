@@ -0,0 +1,196 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// Revocation records a revoked key.
+type Revocation struct {
+	KeyID   string
+	Revoked time.Time
+	Reason  string
+}
+
+func revokePaths(b *backend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern:      "key/" + keyIDRegex("name") + "/revoke",
+			HelpSynopsis: ``,
+			Fields: map[string]*framework.FieldSchema{
+				"name":   &framework.FieldSchema{Type: framework.TypeString},
+				"reason": &framework.FieldSchema{Type: framework.TypeString},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathKeyRevoke,
+			},
+		},
+		&framework.Path{
+			Pattern:      "revoked/?",
+			HelpSynopsis: ``,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.pathRevokedList,
+			},
+		},
+		&framework.Path{
+			Pattern:      "revoked/" + keyIDRegex("name"),
+			HelpSynopsis: ``,
+			Fields: map[string]*framework.FieldSchema{
+				"name": &framework.FieldSchema{Type: framework.TypeString},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathRevokedRead,
+			},
+		},
+	}
+}
+
+// pathKeyRevoke unpublishes a key, stops it from signing and rotates every
+// key ring that was signing with it.
+func (b *backend) pathKeyRevoke(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	keyID := data.Get("name").(string)
+
+	key, err := b.getKey(ctx, req, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return errorResponse(fmt.Errorf("no such key %q", keyID))
+	}
+
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	revocation := &Revocation{
+		KeyID:   keyID,
+		Revoked: time.Now().UTC(),
+		Reason:  data.Get("reason").(string),
+	}
+
+	entry, err := logical.StorageEntryJSON(path.Join("revoked", keyID), revocation)
+	if err != nil {
+		return nil, err
+	}
+
+	err = req.Storage.Put(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := lockPublicKey(keyID)
+	for _, storagePath := range []string{
+		path.Join("key", keyID),
+		path.Join("importedkey", keyID),
+	} {
+		err = req.Storage.Delete(ctx, storagePath)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+	}
+	unlock()
+
+	b.invalidateKey(keyID)
+	b.importedKeys.Invalidate(keyID)
+
+	rings, err := b.listKeyRings(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range rings {
+		ring := b.Ring(name)
+
+		err = ring.DiscardNextKey(ctx, req, keyID)
+		if err != nil {
+			return nil, err
+		}
+
+		current, err := ring.readKey(ctx, req, "privatekey")
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || current.ID != keyID {
+			continue
+		}
+
+		ringConf, err := b.getKeyRingConfig(ctx, req, conf, name)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = ring.Rotate(ctx, req, ringConf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return revocation.response(), nil
+}
+
+func (b *backend) pathRevokedList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	vals, err := req.Storage.List(ctx, "revoked/")
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(vals), nil
+}
+
+func (b *backend) pathRevokedRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	revocation, err := b.getRevocation(ctx, req, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if revocation == nil {
+		return nil, nil
+	}
+
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// revoked/<kid> can be read without a token
+	resp := revocation.response()
+	if conf.RedactRevocationReason {
+		delete(resp.Data, "reason")
+	}
+
+	return resp, nil
+}
+
+func (r *Revocation) response() *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":    r.KeyID,
+			"revoked": r.Revoked.Unix(),
+			"reason":  r.Reason,
+		},
+	}
+}
+
+func (b *backend) getRevocation(ctx context.Context, req *logical.Request, keyID string) (*Revocation, error) {
+	entry, err := req.Storage.Get(ctx, path.Join("revoked", keyID))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var revocation *Revocation
+
+	err = entry.DecodeJSON(&revocation)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal failed: %v", err)
+	}
+
+	return revocation, nil
+}
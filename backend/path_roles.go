@@ -24,11 +24,22 @@ func rolePaths(b *backend) []*framework.Path {
 			Pattern:      "role/" + framework.GenericNameRegex("name"),
 			HelpSynopsis: ``,
 			Fields: map[string]*framework.FieldSchema{
-				"name":      &framework.FieldSchema{Type: framework.TypeNameString},
-				"defaults":  &framework.FieldSchema{Type: framework.TypeString},
-				"overrides": &framework.FieldSchema{Type: framework.TypeString},
-				"schema":    &framework.FieldSchema{Type: framework.TypeString},
-				"ttl":       &framework.FieldSchema{Type: framework.TypeDurationSecond, Default: 3600},
+				"name":        &framework.FieldSchema{Type: framework.TypeNameString},
+				"defaults":    &framework.FieldSchema{Type: framework.TypeString},
+				"overrides":   &framework.FieldSchema{Type: framework.TypeString},
+				"schema":      &framework.FieldSchema{Type: framework.TypeString},
+				"ttl":         &framework.FieldSchema{Type: framework.TypeDurationSecond},
+				"max_ttl":     &framework.FieldSchema{Type: framework.TypeDurationSecond},
+				"signing_key": &framework.FieldSchema{Type: framework.TypeString},
+				"key_ring":    &framework.FieldSchema{Type: framework.TypeString},
+				"include_x5t": &framework.FieldSchema{Type: framework.TypeBool},
+
+				"allowed_claims":       &framework.FieldSchema{Type: framework.TypeCommaStringSlice},
+				"denied_claims":        &framework.FieldSchema{Type: framework.TypeCommaStringSlice},
+				"allowed_claim_values": &framework.FieldSchema{Type: framework.TypeString},
+				"unknown_claims":       &framework.FieldSchema{Type: framework.TypeString},
+
+				"allowed_audiences": &framework.FieldSchema{Type: framework.TypeCommaStringSlice},
 			},
 			ExistenceCheck: b.pathRoleExistenceCheck,
 			Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -44,6 +55,8 @@ func rolePaths(b *backend) []*framework.Path {
 			Fields: map[string]*framework.FieldSchema{
 				"rolename": &framework.FieldSchema{Type: framework.TypeNameString},
 				"claims":   &framework.FieldSchema{Type: framework.TypeString},
+				"ttl":      &framework.FieldSchema{Type: framework.TypeDurationSecond},
+				"audience": &framework.FieldSchema{Type: framework.TypeCommaStringSlice},
 			},
 			ExistenceCheck: b.pathRoleExistenceCheck,
 			Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -54,8 +67,6 @@ func rolePaths(b *backend) []*framework.Path {
 	}
 }
 
-// EntityID
-
 func (b *backend) pathRoleList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	vals, err := req.Storage.List(ctx, "role/")
 	if err != nil {
@@ -84,16 +95,32 @@ func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *
 
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"name":      data.Get("name").(string),
-			"defaults":  string(role.Defaults),
-			"overrides": string(role.Overrides),
-			"schema":    string(role.Schema),
-			"ttl":       role.TTL,
+			"name":        data.Get("name").(string),
+			"defaults":    string(role.Defaults),
+			"overrides":   string(role.Overrides),
+			"schema":      string(role.Schema),
+			"ttl":         role.TTL,
+			"max_ttl":     role.MaxTTL,
+			"signing_key": role.SigningKey,
+			"key_ring":    role.KeyRing,
+			"include_x5t": role.IncludeX5T,
+
+			"allowed_claims":       role.AllowedClaims,
+			"denied_claims":        role.DeniedClaims,
+			"allowed_claim_values": string(role.AllowedClaimValues),
+			"unknown_claims":       role.UnknownClaims,
+
+			"allowed_audiences": role.AllowedAudiences,
 		},
 	}, nil
 }
 
 func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
 	role, err := b.getRole(ctx, req, data.Get("name").(string))
 	if err != nil {
 		return nil, err
@@ -102,15 +129,91 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 		role = &Role{}
 	}
 
-	role.Defaults = []byte(data.Get("defaults").(string))
-	role.Overrides = []byte(data.Get("overrides").(string))
-	role.Schema = []byte(data.Get("schema").(string))
-	role.TTL = data.Get("ttl").(int)
+	// Omitted fields keep their stored value; empty values clear them.
+	if v, ok := data.GetOk("defaults"); ok {
+		role.Defaults = []byte(v.(string))
+	}
+	if v, ok := data.GetOk("overrides"); ok {
+		role.Overrides = []byte(v.(string))
+	}
+	if v, ok := data.GetOk("schema"); ok {
+		role.Schema = []byte(v.(string))
+	}
+	if v, ok := data.GetOk("ttl"); ok {
+		role.TTL = v.(int)
+	}
+	if v, ok := data.GetOk("max_ttl"); ok {
+		role.MaxTTL = v.(int)
+	}
+	if role.MaxTTL < 0 || role.MaxTTL > conf.MaxTTL {
+		role.MaxTTL = conf.MaxTTL
+	}
 	if role.TTL <= 0 {
-		role.TTL = 3600 // 1h
+		role.TTL = conf.DefaultTTL
+	}
+	role.TTL = role.grantTTL(0, conf)
+
+	if v, ok := data.GetOk("signing_key"); ok {
+		role.SigningKey = v.(string)
+		if role.SigningKey != "" {
+			key, err := readPrivateKey(ctx, req, path.Join("importedkey", role.SigningKey))
+			if err != nil {
+				return nil, err
+			}
+			if key == nil {
+				return errorResponse(fmt.Errorf("no imported key %q", role.SigningKey))
+			}
+		}
 	}
-	if role.TTL > 86400 {
-		role.TTL = 86400 // 24h
+
+	if v, ok := data.GetOk("key_ring"); ok {
+		role.KeyRing = v.(string)
+		if role.KeyRing != "" {
+			ringConf, err := b.getKeyRingConfig(ctx, req, conf, role.KeyRing)
+			if err != nil {
+				return nil, err
+			}
+			if ringConf == nil {
+				return errorResponse(fmt.Errorf("no key ring %q", role.KeyRing))
+			}
+		}
+	}
+
+	if role.SigningKey != "" && role.KeyRing != "" {
+		return errorResponse(errors.New("signing_key and key_ring are mutually exclusive"))
+	}
+
+	if v, ok := data.GetOk("include_x5t"); ok {
+		role.IncludeX5T = v.(bool)
+	}
+
+	if v, ok := data.GetOk("allowed_claims"); ok {
+		role.AllowedClaims = v.([]string)
+	}
+	if role.AllowedClaims == nil {
+		role.AllowedClaims = []string{}
+	}
+	if v, ok := data.GetOk("denied_claims"); ok {
+		role.DeniedClaims = v.([]string)
+	}
+	if role.DeniedClaims == nil {
+		role.DeniedClaims = []string{}
+	}
+	if v, ok := data.GetOk("allowed_claim_values"); ok {
+		role.AllowedClaimValues = []byte(v.(string))
+	}
+	if v, ok := data.GetOk("unknown_claims"); ok {
+		role.UnknownClaims = v.(string)
+	}
+	if role.UnknownClaims == "" {
+		role.UnknownClaims = "reject"
+	}
+
+	if v, ok := data.GetOk("allowed_audiences"); ok {
+		role.AllowedAudiences = v.([]string)
+	}
+	if role.AllowedAudiences == nil {
+		role.AllowedAudiences = []string{}
 	}
 
 	err = role.Validate()
@@ -159,6 +262,27 @@ func (b *backend) getRole(ctx context.Context, req *logical.Request, roleName st
 	return role, nil
 }
 
+// findRole returns the name of the first role that matches, or "" when no
+// role matches.
+func (b *backend) findRole(ctx context.Context, req *logical.Request, match func(*Role) bool) (string, error) {
+	roles, err := req.Storage.List(ctx, "role/")
+	if err != nil {
+		return "", err
+	}
+
+	for _, roleName := range roles {
+		role, err := b.getRole(ctx, req, roleName)
+		if err != nil {
+			return "", err
+		}
+		if role != nil && match(role) {
+			return roleName, nil
+		}
+	}
+
+	return "", nil
+}
+
 func (b *backend) pathRoleSign(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	role, err := b.getRole(ctx, req, data.Get("rolename").(string))
 	if err != nil {
@@ -168,20 +292,61 @@ func (b *backend) pathRoleSign(ctx context.Context, req *logical.Request, data *
 		return nil, errors.New("no such role")
 	}
 
+	conf, err := b.getConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.EntityID != "" && role.usesIdentity() {
+		role.entity, err = b.System().EntityInfo(req.EntityID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = role.setAudiences(data.Get("audience").([]string))
+	if err != nil {
+		return errorResponse(err)
+	}
+
 	claims := []byte(data.Get("claims").(string))
+	role.TTL = role.grantTTL(data.Get("ttl").(int), conf)
 
-	jwtClaims, expires, err := role.BuildClaims(claims, req.ID)
+	jwtClaims, expires, err := role.BuildClaims(claims, req.ID, conf)
 	if err != nil {
 		return nil, err
 	}
 
-	key, err := b.currentKey.Get(ctx, req)
+	key, err := b.getSigningKey(ctx, req, conf, role)
 	if err != nil {
 		return nil, err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwtClaims)
+	method, err := key.SigningMethod()
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.recordTokenExpiry(ctx, req, conf, key.ID, expires)
+	if err != nil {
+		return nil, err
+	}
+
+	token := jwt.NewWithClaims(method, jwtClaims)
 	token.Header["kid"] = key.ID
+	if role.IncludeX5T {
+		pub, err := b.getKey(ctx, req, key.ID)
+		if err != nil {
+			return nil, err
+		}
+		if pub == nil || len(pub.Certificate) == 0 {
+			return errorResponse(fmt.Errorf(
+				"role requires an x5t#S256 header but signing key %q has no certificate; "+
+					"upload one to key/%s/certificate or enable self_signed_certificates",
+				key.ID, key.ID))
+		}
+		token.Header["x5t#S256"] = certificateThumbprint(pub.Certificate)
+	}
 	jwtToken, err := token.SignedString(key.prvKey)
 	if err != nil {
 		return nil, err
@@ -191,6 +356,24 @@ func (b *backend) pathRoleSign(ctx context.Context, req *logical.Request, data *
 		Data: map[string]interface{}{
 			"token":   jwtToken,
 			"expires": expires.Unix(),
+			"ttl":     role.TTL,
 		},
 	}, nil
 }
+
+// getSigningKey returns the private key a role signs with.
+func (b *backend) getSigningKey(ctx context.Context, req *logical.Request, conf *Config, role *Role) (*PrivateKey, error) {
+	if role.SigningKey != "" {
+		return b.getImportedKey(ctx, req, role.SigningKey)
+	}
+
+	ringConf, err := b.getKeyRingConfig(ctx, req, conf, role.keyRing())
+	if err != nil {
+		return nil, err
+	}
+	if ringConf == nil {
+		return nil, fmt.Errorf("no such key ring %q", role.keyRing())
+	}
+
+	return b.Ring(role.keyRing()).Get(ctx, req, ringConf)
+}
@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// TidyStatus reports the last run of tidy.
+type TidyStatus struct {
+	Started  time.Time
+	Finished time.Time
+	Deleted  []string
+	Errors   []string
+}
+
+func tidyPaths(b *backend) []*framework.Path {
+	return []*framework.Path{
+		&framework.Path{
+			Pattern:      "tidy",
+			HelpSynopsis: ``,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.pathTidy,
+			},
+		},
+		&framework.Path{
+			Pattern:      "tidy/status",
+			HelpSynopsis: ``,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.pathTidyStatusRead,
+			},
+		},
+	}
+}
+
+func (b *backend) pathTidy(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	status, err := b.tidy(ctx, req, time.Now(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return status.response(), nil
+}
+
+func (b *backend) pathTidyStatusRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := req.Storage.Get(ctx, "tidy/status")
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var status *TidyStatus
+
+	err = entry.DecodeJSON(&status)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal failed: %v", err)
+	}
+
+	return status.response(), nil
+}
+
+// tidy deletes expired public keys, logs every failure and records the
+// outcome at tidy/status. Periodic runs only record runs that deleted keys or
+// whose errors changed, so idle mounts don't write storage every minute. Only
+// storage failures while recording the status are returned.
+func (b *backend) tidy(ctx context.Context, req *logical.Request, now time.Time, manual bool) (*TidyStatus, error) {
+	b.tidyMtx.Lock()
+	defer b.tidyMtx.Unlock()
+
+	status := &TidyStatus{
+		Started: time.Now().UTC(),
+		Deleted: []string{},
+		Errors:  []string{},
+	}
+
+	deleted, err := b.cleanExpiredPublicKeys(ctx, req, now)
+	status.Deleted = append(status.Deleted, deleted...)
+
+	var errs []error
+	if merr, ok := err.(*multierror.Error); ok {
+		errs = merr.Errors
+	} else if err != nil {
+		errs = []error{err}
+	}
+	for _, err := range errs {
+		b.Logger().Error("tidy failed", "error", err)
+		status.Errors = append(status.Errors, err.Error())
+	}
+
+	status.Finished = time.Now().UTC()
+
+	if !manual && len(status.Deleted) == 0 && equalStrings(status.Errors, b.tidyErrors) {
+		return status, nil
+	}
+
+	entry, err := logical.StorageEntryJSON("tidy/status", status)
+	if err != nil {
+		return nil, err
+	}
+
+	err = req.Storage.Put(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	b.tidyErrors = status.Errors
+	return status, nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *TidyStatus) response() *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"started":  s.Started.Unix(),
+			"finished": s.Finished.Unix(),
+			"deleted":  s.Deleted,
+			"errors":   s.Errors,
+		},
+	}
+}
@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestRevokeKey(t *testing.T) {
+	b, storage := newTestBackend(t)
+
+	sign := func() string {
+		t.Helper()
+
+		resp, err := b.HandleRequest(testCtx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "sign/foo",
+			Storage:   storage,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatal(err, resp)
+		}
+
+		return tokenHeader(t, resp.Data["token"].(string))["kid"].(string)
+	}
+
+	resp, err := b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/foo",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err, resp)
+	}
+
+	keyID := sign()
+
+	resp, err = b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "key/" + keyID + "/revoke",
+		Storage:   storage,
+		Data:      map[string]interface{}{"reason": "leaked"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err, resp)
+	}
+
+	key, err := b.getKey(testCtx, &logical.Request{Storage: storage}, keyID)
+	assert(t, err)
+	if key != nil {
+		t.Fatal("expected the revoked key to be unpublished")
+	}
+
+	jwks, err := b.getJWKS(testCtx, &logical.Request{Storage: storage}, time.Now())
+	assert(t, err)
+	for _, jwk := range jwks.Keys {
+		if jwk.KeyID == keyID {
+			t.Fatal("expected the revoked key to be removed from the JWKS")
+		}
+	}
+
+	if sign() == keyID {
+		t.Fatal("expected the key ring to be rotated")
+	}
+
+	resp, err = b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "revoked/" + keyID,
+		Storage:   storage,
+	})
+	if err != nil || resp == nil {
+		t.Fatal(err, resp)
+	}
+	if _, ok := resp.Data["reason"]; ok || resp.Data["revoked"] == nil {
+		t.Fatalf("expected the reason to be redacted but received %v", resp.Data)
+	}
+
+	// Revocations can be read without a token.
+	for _, p := range []string{"revoked/", "revoked/" + keyID} {
+		if !unauthenticated(b, p) {
+			t.Fatalf("expected %s to be readable without a token", p)
+		}
+	}
+
+	resp, err = b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      map[string]interface{}{"redact_revocation_reason": false},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatal(err, resp)
+	}
+
+	resp, err = b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "revoked/" + keyID,
+		Storage:   storage,
+	})
+	if err != nil || resp == nil {
+		t.Fatal(err, resp)
+	}
+	if resp.Data["reason"] != "leaked" || resp.Data["revoked"] == nil {
+		t.Fatalf("unexpected revocation %v", resp.Data)
+	}
+
+	resp, err = b.HandleRequest(testCtx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "key/" + keyID + "/revoke",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != 400 {
+		t.Fatalf("expected a 400 response but received %v", resp)
+	}
+}
+
+// unauthenticated reports whether Vault serves p without a token. Like Vault,
+// it only treats special paths ending in "*" as prefixes.
+func unauthenticated(b *backend, p string) bool {
+	for _, special := range b.PathsSpecial.Unauthenticated {
+		if strings.HasSuffix(special, "*") {
+			if strings.HasPrefix(p, strings.TrimSuffix(special, "*")) {
+				return true
+			}
+		} else if p == special {
+			return true
+		}
+	}
+	return false
+}
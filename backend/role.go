@@ -2,20 +2,37 @@ package backend
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/logical"
 	"github.com/qri-io/jsonschema"
+	glob "github.com/ryanuber/go-glob"
 )
 
 type Role struct {
-	Overrides []byte
-	Defaults  []byte
-	Schema    []byte
-	TTL       int
-
-	now time.Time
+	Overrides  []byte
+	Defaults   []byte
+	Schema     []byte
+	TTL        int
+	MaxTTL     int
+	SigningKey string
+	KeyRing    string
+	IncludeX5T bool
+
+	AllowedClaims      []string
+	DeniedClaims       []string
+	AllowedClaimValues []byte
+	UnknownClaims      string
+
+	AllowedAudiences []string
+
+	now       time.Time
+	entity    *logical.Entity
+	audiences []string
 }
 
 var bareUserSchema = jsonschema.Must(`
@@ -92,7 +109,129 @@ var overridesSchema = jsonschema.Must(`
 }
 `)
 
-func (r *Role) BuildClaims(claimsJSON []byte, jti string) (jwt.Claims, time.Time, error) {
+// keyRing returns the key ring the role signs with.
+func (r *Role) keyRing() string {
+	if r.KeyRing == "" {
+		return defaultKeyRing
+	}
+	return r.KeyRing
+}
+
+// grantTTL returns the TTL of a token for a requested TTL, bounded by the
+// role and mount maximums. A zero TTL requests the role's default.
+func (r *Role) grantTTL(requested int, conf *Config) int {
+	ttl := r.TTL
+	if requested > 0 {
+		ttl = requested
+	}
+	if r.MaxTTL > 0 && ttl > r.MaxTTL {
+		ttl = r.MaxTTL
+	}
+	if conf != nil && conf.MaxTTL > 0 && ttl > conf.MaxTTL {
+		ttl = conf.MaxTTL
+	}
+	return ttl
+}
+
+// setAudiences selects the audiences of the next token. Every audience must
+// be one of the role's allowed audiences.
+func (r *Role) setAudiences(audiences []string) error {
+	for _, aud := range audiences {
+		allowed := false
+		for _, a := range r.AllowedAudiences {
+			if a == aud {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("audience %q is not allowed", aud)
+		}
+	}
+
+	r.audiences = audiences
+	return nil
+}
+
+// filterClaims applies the claim policy of the role to the requested claims.
+// Claims and values that are not allowed are removed when the role strips
+// unknown claims and rejected otherwise.
+func (r *Role) filterClaims(claims map[string]interface{}) error {
+	var (
+		result        error
+		valuePatterns map[string][]string
+		strip         = r.UnknownClaims == "strip"
+	)
+
+	if len(r.AllowedClaimValues) > 0 {
+		if err := json.Unmarshal(r.AllowedClaimValues, &valuePatterns); err != nil {
+			return err
+		}
+	}
+
+	for name, value := range claims {
+		if !r.claimAllowed(name) {
+			if strip {
+				delete(claims, name)
+			} else {
+				result = multierror.Append(result, fmt.Errorf("claim %q is not allowed", name))
+			}
+			continue
+		}
+
+		patterns, ok := valuePatterns[name]
+		if !ok {
+			continue
+		}
+
+		switch x := value.(type) {
+		case string:
+			if matchAny(patterns, x) {
+				continue
+			}
+
+		case []interface{}:
+			values := []interface{}{}
+			for _, v := range x {
+				if s, ok := v.(string); ok && matchAny(patterns, s) {
+					values = append(values, v)
+				} else if !strip {
+					result = multierror.Append(result, fmt.Errorf("value %v of claim %q is not allowed", v, name))
+				}
+			}
+			claims[name] = values
+			continue
+		}
+
+		if strip {
+			delete(claims, name)
+		} else {
+			result = multierror.Append(result, fmt.Errorf("value %v of claim %q is not allowed", value, name))
+		}
+	}
+
+	return result
+}
+
+// claimAllowed reports whether a requested claim matches the allowed claims
+// and none of the denied claims of the role.
+func (r *Role) claimAllowed(name string) bool {
+	if matchAny(r.DeniedClaims, name) {
+		return false
+	}
+	return len(r.AllowedClaims) == 0 || matchAny(r.AllowedClaims, name)
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if glob.Glob(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Role) BuildClaims(claimsJSON []byte, jti string, conf *Config) (jwt.Claims, time.Time, error) {
 	var (
 		result        error
 		valErrs       []jsonschema.ValError
@@ -131,6 +270,19 @@ func (r *Role) BuildClaims(claimsJSON []byte, jti string) (jwt.Claims, time.Time
 		return nil, expires, result
 	}
 
+	// Resolve identity templates
+	overrides, err := populateTemplates(overrides, r.entity)
+	if err != nil {
+		result = multierror.Append(result, err)
+		return nil, expires, result
+	}
+
+	defaults, err = populateTemplates(defaults, r.entity)
+	if err != nil {
+		result = multierror.Append(result, err)
+		return nil, expires, result
+	}
+
 	// validate with basic schema
 	{
 		bareUserSchema.Validate("/", claims, &valErrs)
@@ -172,6 +324,12 @@ func (r *Role) BuildClaims(claimsJSON []byte, jti string) (jwt.Claims, time.Time
 
 	if u, ok := claims.(map[string]interface{}); ok && u != nil {
 
+		// Apply the claim policy
+		if err := r.filterClaims(u); err != nil {
+			result = multierror.Append(result, err)
+			return nil, expires, result
+		}
+
 		// Apply default claims
 		if d, ok := defaults.(map[string]interface{}); ok && d != nil {
 			for k, v := range d {
@@ -181,6 +339,17 @@ func (r *Role) BuildClaims(claimsJSON []byte, jti string) (jwt.Claims, time.Time
 			}
 		}
 
+		// Apply the requested audiences
+		if len(r.audiences) == 1 {
+			u["aud"] = r.audiences[0]
+		} else if len(r.audiences) > 1 {
+			aud := make([]interface{}, len(r.audiences))
+			for i, a := range r.audiences {
+				aud[i] = a
+			}
+			u["aud"] = aud
+		}
+
 		// Apply static claims
 		if s, ok := overrides.(map[string]interface{}); ok && s != nil {
 			for k, v := range s {
@@ -188,6 +357,13 @@ func (r *Role) BuildClaims(claimsJSON []byte, jti string) (jwt.Claims, time.Time
 			}
 		}
 
+		// Apply the mount issuer
+		if conf != nil && conf.Issuer != "" {
+			if _, f := u["iss"]; !f {
+				u["iss"] = conf.Issuer
+			}
+		}
+
 	}
 
 	// validate with role defined schema
@@ -227,7 +403,8 @@ func (r *Role) BuildClaims(claimsJSON []byte, jti string) (jwt.Claims, time.Time
 	if !r.now.IsZero() {
 		now = r.now
 	}
-	expires = now.Add(time.Duration(r.TTL) * time.Second)
+	ttl := r.grantTTL(0, conf)
+	expires = now.Add(time.Duration(ttl) * time.Second)
 	allClaims := jwt.MapClaims(claims.(map[string]interface{}))
 	allClaims["iat"] = now.Unix()
 	allClaims["exp"] = expires.Unix()
@@ -266,6 +443,34 @@ func (r *Role) Validate() error {
 		return result
 	}
 
+	if err := checkTemplates(overrides); err != nil {
+		result = multierror.Append(result, err)
+		return result
+	}
+
+	if err := checkTemplates(defaults); err != nil {
+		result = multierror.Append(result, err)
+		return result
+	}
+
+	if len(r.AllowedClaimValues) > 0 {
+		var valuePatterns map[string][]string
+		if err := json.Unmarshal(r.AllowedClaimValues, &valuePatterns); err != nil {
+			result = multierror.Append(result, fmt.Errorf("allowed_claim_values must map claims to lists of patterns: %v", err))
+			return result
+		}
+	}
+
+	if o, ok := overrides.(map[string]interface{}); ok && o["aud"] != nil && len(r.AllowedAudiences) > 0 {
+		result = multierror.Append(result, errors.New("allowed_audiences can not be combined with an aud override"))
+		return result
+	}
+
+	if r.UnknownClaims != "" && r.UnknownClaims != "reject" && r.UnknownClaims != "strip" {
+		result = multierror.Append(result, errors.New("unknown_claims must be reject or strip"))
+		return result
+	}
+
 	// validate with basic schema
 	{
 		overridesSchema.Validate("/", overrides, &valErrs)
@@ -9,11 +9,13 @@ import (
 	"time"
 
 	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/logical"
 )
 
 func TestRoleBuildClaims(t *testing.T) {
 	test := func(
 		role *Role,
+		conf *Config,
 		extra string,
 		expected string,
 		expectedErr string,
@@ -22,7 +24,7 @@ func TestRoleBuildClaims(t *testing.T) {
 
 		role.now = time.Date(2018, 12, 28, 10, 14, 00, 00, time.UTC)
 
-		claims, _, err := role.BuildClaims([]byte(extra), "xyz")
+		claims, _, err := role.BuildClaims([]byte(extra), "xyz", conf)
 
 		if toJSON(t, claims) != compactJSON(t, expected) {
 			t.Errorf("\nexpected: %s\nactual:   %s", compactJSON(t, expected), toJSON(t, claims))
@@ -44,6 +46,7 @@ func TestRoleBuildClaims(t *testing.T) {
 				{"scopes":["https://example.com", "https://example.net"], "cap":["xx"]}
 			`),
 		},
+		nil,
 		`{"scopes":["https://example.com"]}`,
 		`{
 			"aud":["https://example.com"],
@@ -59,6 +62,7 @@ func TestRoleBuildClaims(t *testing.T) {
 
 	test(
 		&Role{TTL: 3600},
+		nil,
 		``,
 		`{
 			"exp":1545995640,
@@ -71,6 +75,7 @@ func TestRoleBuildClaims(t *testing.T) {
 
 	test(
 		&Role{TTL: 3600},
+		nil,
 		`{
 			"aud":["https://example.com"],
 			"iss":"baz",
@@ -100,6 +105,7 @@ func TestRoleBuildClaims(t *testing.T) {
 				"nbf":3
 			}`),
 		},
+		nil,
 		``,
 		`null`,
 		"4 errors occurred:\n"+
@@ -121,6 +127,7 @@ func TestRoleBuildClaims(t *testing.T) {
 				"nbf":3
 			}`),
 		},
+		nil,
 		``,
 		`null`,
 		"3 errors occurred:\n"+
@@ -141,6 +148,7 @@ func TestRoleBuildClaims(t *testing.T) {
 				"nbf":3
 			}`),
 		},
+		nil,
 		``,
 		`null`,
 		"6 errors occurred:\n"+
@@ -164,6 +172,7 @@ func TestRoleBuildClaims(t *testing.T) {
 				}
 			}`),
 		},
+		nil,
 		`{"scopes": ["https://example.com/scope-c"]}`,
 		`null`,
 		"1 error occurred:\n"+
@@ -182,6 +191,7 @@ func TestRoleBuildClaims(t *testing.T) {
 				}
 			}`),
 		},
+		nil,
 		`{"scopes": ["https://example.com/scope-a"]}`,
 		`{
 			"exp":1545995640,
@@ -193,6 +203,169 @@ func TestRoleBuildClaims(t *testing.T) {
 		``,
 	)
 
+	test(
+		&Role{TTL: 3600},
+		&Config{Issuer: "https://vault.example.com/v1/jwt"},
+		``,
+		`{
+			"exp":1545995640,
+			"iat":1545992040,
+			"iss":"https://vault.example.com/v1/jwt",
+			"jti":"xyz",
+			"nbf":1545991740
+		}`,
+		``,
+	)
+
+	test(
+		&Role{
+			TTL:       3600,
+			Overrides: []byte(`{"iss":"https://example.com"}`),
+		},
+		&Config{Issuer: "https://vault.example.com/v1/jwt"},
+		``,
+		`{
+			"exp":1545995640,
+			"iat":1545992040,
+			"iss":"https://example.com",
+			"jti":"xyz",
+			"nbf":1545991740
+		}`,
+		``,
+	)
+
+	test(
+		&Role{
+			TTL:                3600,
+			AllowedClaims:      []string{"sub", "scope", "x-*"},
+			DeniedClaims:       []string{"x-internal-*"},
+			AllowedClaimValues: []byte(`{"scope":["posts.*"]}`),
+			UnknownClaims:      "strip",
+		},
+		nil,
+		`{"sub":"alice","scope":["posts.read","users.write"],"x-trace":"1","x-internal-id":"2","admin":true}`,
+		`{
+			"exp":1545995640,
+			"iat":1545992040,
+			"jti":"xyz",
+			"nbf":1545991740,
+			"scope":["posts.read"],
+			"sub":"alice",
+			"x-trace":"1"
+		}`,
+		``,
+	)
+
+	test(
+		&Role{
+			TTL:                3600,
+			AllowedClaims:      []string{"sub", "scope"},
+			AllowedClaimValues: []byte(`{"scope":["posts.*"]}`),
+			UnknownClaims:      "reject",
+		},
+		nil,
+		`{"scope":"users.write","admin":true}`,
+		`null`,
+		"2 errors occurred:\n"+
+			"	* claim \"admin\" is not allowed\n"+
+			"	* value users.write of claim \"scope\" is not allowed\n",
+	)
+
+	entity := &logical.Entity{
+		ID:       "e5a1b2c3",
+		Name:     "alice",
+		Metadata: map[string]string{"team": "blog"},
+		Aliases: []*logical.Alias{
+			{MountAccessor: "auth_github_1234", Name: "alice-gh"},
+		},
+	}
+
+	test(
+		&Role{
+			TTL:       3600,
+			Overrides: []byte(`{"sub":"{{identity.entity.id}}","team":"{{ identity.entity.metadata.team }}"}`),
+			Defaults:  []byte(`{"name":"{{identity.entity.name}}","github":["user/{{identity.entity.aliases.auth_github_1234.name}}"]}`),
+			entity:    entity,
+		},
+		nil,
+		`{"sub":"bob","name":"bob"}`,
+		`{
+			"exp":1545995640,
+			"github":["user/alice-gh"],
+			"iat":1545992040,
+			"jti":"xyz",
+			"name":"bob",
+			"nbf":1545991740,
+			"sub":"e5a1b2c3",
+			"team":"blog"
+		}`,
+		``,
+	)
+
+	test(
+		&Role{
+			TTL:       3600,
+			Overrides: []byte(`{"team":"{{identity.entity.metadata.region}}"}`),
+			entity:    entity,
+		},
+		nil,
+		``,
+		`null`,
+		"1 error occurred:\n"+
+			"	* no value for template \"identity.entity.metadata.region\"\n",
+	)
+
+	test(
+		&Role{
+			TTL:       3600,
+			Overrides: []byte(`{"sub":"{{identity.entity.id}}"}`),
+		},
+		nil,
+		``,
+		`null`,
+		"1 error occurred:\n"+
+			"	* template \"identity.entity.id\" requires an identity entity\n",
+	)
+
+}
+
+func TestRoleValidateTemplates(t *testing.T) {
+	role := &Role{Overrides: []byte(`{"sub":"{{identity.entity.email}}"}`)}
+	if errString(role.Validate()) == "" {
+		t.Fatal("expected an unsupported template to be rejected")
+	}
+
+	role = &Role{Defaults: []byte(`{"team":"{{identity.entity.aliases.auth_github_1234.metadata.org}}"}`)}
+	assert(t, role.Validate())
+}
+
+func TestRoleValidateClaimPolicy(t *testing.T) {
+	role := &Role{AllowedClaimValues: []byte(`{"scope":"posts.*"}`)}
+	if errString(role.Validate()) == "" {
+		t.Fatal("expected allowed_claim_values without pattern lists to be rejected")
+	}
+
+	role = &Role{UnknownClaims: "ignore"}
+	if errString(role.Validate()) == "" {
+		t.Fatal("expected an invalid unknown_claims to be rejected")
+	}
+
+	role = &Role{AllowedClaimValues: []byte(`{"scope":["posts.*"]}`), UnknownClaims: "strip"}
+	assert(t, role.Validate())
+}
+
+func TestRoleValidateAudiences(t *testing.T) {
+	role := &Role{
+		Overrides:        []byte(`{"aud":"https://example.com"}`),
+		AllowedAudiences: []string{"https://example.net"},
+	}
+	if errString(role.Validate()) == "" {
+		t.Fatal("expected allowed_audiences with an aud override to be rejected")
+	}
+
+	role.Overrides = nil
+	role.Defaults = []byte(`{"aud":"https://example.com"}`)
+	assert(t, role.Validate())
 }
 
 func assert(t testing.TB, err error) {
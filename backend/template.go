@@ -0,0 +1,164 @@
+package backend
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// templateRegexp matches identity templates like {{identity.entity.id}}.
+var templateRegexp = regexp.MustCompile(`\{\{\s*(identity\.[^{}]*?)\s*\}\}`)
+
+// populateTemplates replaces the identity templates in the string values of
+// v with the attributes of entity.
+func populateTemplates(v interface{}, entity *logical.Entity) (interface{}, error) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, e := range x {
+			e, err := populateTemplates(e, entity)
+			if err != nil {
+				return nil, err
+			}
+			x[k] = e
+		}
+		return x, nil
+
+	case []interface{}:
+		for i, e := range x {
+			e, err := populateTemplates(e, entity)
+			if err != nil {
+				return nil, err
+			}
+			x[i] = e
+		}
+		return x, nil
+
+	case string:
+		var result error
+		s := templateRegexp.ReplaceAllStringFunc(x, func(match string) string {
+			value, err := resolveTemplate(templateRegexp.FindStringSubmatch(match)[1], entity)
+			if err != nil && result == nil {
+				result = err
+			}
+			return value
+		})
+		if result != nil {
+			return nil, result
+		}
+		return s, nil
+
+	default:
+		return v, nil
+	}
+}
+
+// usesIdentity reports whether the defaults or overrides of the role contain
+// identity templates, which need the caller's entity.
+func (r *Role) usesIdentity() bool {
+	return templateRegexp.Match(r.Defaults) || templateRegexp.Match(r.Overrides)
+}
+
+// checkTemplates returns an error when v contains an unsupported identity
+// template.
+func checkTemplates(v interface{}) error {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for _, e := range x {
+			if err := checkTemplates(e); err != nil {
+				return err
+			}
+		}
+
+	case []interface{}:
+		for _, e := range x {
+			if err := checkTemplates(e); err != nil {
+				return err
+			}
+		}
+
+	case string:
+		for _, match := range templateRegexp.FindAllStringSubmatch(x, -1) {
+			if _, err := parseTemplate(match[1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseTemplate splits an identity template into its path below
+// identity.entity.
+func parseTemplate(name string) ([]string, error) {
+	parts := strings.Split(name, ".")
+	if len(parts) < 3 || parts[0] != "identity" || parts[1] != "entity" {
+		return nil, fmt.Errorf("unsupported template %q", name)
+	}
+	parts = parts[2:]
+
+	switch {
+	case len(parts) == 1 && (parts[0] == "id" || parts[0] == "name"):
+	case len(parts) == 2 && parts[0] == "metadata":
+	case len(parts) == 3 && parts[0] == "aliases" && parts[2] == "name":
+	case len(parts) == 4 && parts[0] == "aliases" && parts[2] == "metadata":
+	default:
+		return nil, fmt.Errorf("unsupported template %q", name)
+	}
+
+	return parts, nil
+}
+
+func resolveTemplate(name string, entity *logical.Entity) (string, error) {
+	parts, err := parseTemplate(name)
+	if err != nil {
+		return "", err
+	}
+	if entity == nil {
+		return "", fmt.Errorf("template %q requires an identity entity", name)
+	}
+
+	var (
+		value string
+		found bool
+	)
+
+	switch parts[0] {
+	case "id":
+		value, found = entity.ID, entity.ID != ""
+	case "name":
+		value, found = entity.Name, entity.Name != ""
+	case "metadata":
+		value, found = entity.Metadata[parts[1]]
+	case "aliases":
+		alias := entityAlias(entity, parts[1])
+		if alias == nil {
+			return "", fmt.Errorf("entity has no alias for mount accessor %q", parts[1])
+		}
+		if parts[2] == "name" {
+			value, found = alias.Name, alias.Name != ""
+		} else {
+			value, found = alias.Metadata[parts[3]]
+		}
+	}
+
+	if !found {
+		return "", fmt.Errorf("no value for template %q", name)
+	}
+
+	return value, nil
+}
+
+// entityAlias returns the alias of entity for a mount accessor. Aliases are
+// looked up by accessor rather than mount path because entities from the
+// system view only carry the accessor of their auth mounts, as in Vault's own
+// ACL policy templates.
+func entityAlias(entity *logical.Entity, accessor string) *logical.Alias {
+	for _, alias := range entity.Aliases {
+		if alias.MountAccessor == accessor {
+			return alias
+		}
+	}
+	return nil
+}
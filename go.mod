@@ -1,5 +1,7 @@
 module github.com/fd/vault-plugin-secret-jwt
 
+go 1.15
+
 require (
 	contrib.go.opencensus.io/exporter/ocagent v0.4.1 // indirect
 	github.com/Azure/azure-sdk-for-go v24.0.0+incompatible // indirect
@@ -140,7 +142,7 @@ require (
 	github.com/prometheus/client_golang v0.9.2 // indirect
 	github.com/qri-io/jsonpointer v0.0.0-20180309164927-168dd9e45cf2 // indirect
 	github.com/qri-io/jsonschema v0.0.0-20181220185105-3313399aa0e0
-	github.com/ryanuber/go-glob v0.0.0-20160226084822-572520ed46db // indirect
+	github.com/ryanuber/go-glob v0.0.0-20160226084822-572520ed46db
 	github.com/samuel/go-zookeeper v0.0.0-20180130194729-c4fab1ac1bec // indirect
 	github.com/satori/go.uuid v1.2.0 // indirect
 	github.com/sean-/seed v0.0.0-20170313163322-e2103e2c3529 // indirect
@@ -4,86 +4,160 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
-	"strings"
+	"fmt"
+	"net/http"
+	"regexp"
 	"sync"
+	"time"
 
-	uuid "github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/api"
 )
 
+var (
+	// ErrKeyNotFound is returned by LookupKey when a key is not (or no
+	// longer) published.
+	ErrKeyNotFound = errors.New("signing key not found")
+
+	// ErrKeyRevoked is returned by LookupKey when a key has been revoked.
+	ErrKeyRevoked = errors.New("signing key revoked")
+)
+
+// DefaultCacheTTL is how long a KeySource caches keys unless its CacheTTL is
+// set.
+const DefaultCacheTTL = 5 * time.Minute
+
+// keyIDRegexp matches the key IDs of the backend: UUIDs, thumbprints and the
+// key IDs of imported keys.
+var keyIDRegexp = regexp.MustCompile(`^[\w-]+$`)
+
 type KeySource struct {
+	// CacheTTL is how long a key is cached after it was looked up, and so
+	// how long a revoked key may still be accepted. It must be set before
+	// the first lookup.
+	CacheTTL time.Duration
+
 	mountPath string
 	client    *api.Client
 
 	mtx   sync.RWMutex
-	cache map[string]interface{}
+	cache map[string]cachedKey
+}
+
+type cachedKey struct {
+	key     interface{}
+	expires time.Time
 }
 
 func NewKeySource(vaultClient *api.Client, mountPath string) *KeySource {
 	return &KeySource{
+		CacheTTL:  DefaultCacheTTL,
 		mountPath: mountPath,
 		client:    vaultClient,
-		cache:     make(map[string]interface{}, 64),
+		cache:     make(map[string]cachedKey, 64),
 	}
 }
 
 func (ks *KeySource) LookupKey(keyID string) (interface{}, error) {
 	// validate key id
-	uuid, err := uuid.ParseUUID(keyID)
-	if err != nil || len(uuid) == 0 {
-		return nil, err
+	if !isValidKeyID(keyID) {
+		return nil, fmt.Errorf("invalid key id %q", keyID)
 	}
 
+	now := time.Now()
+
 	// lookup in cache with read lock
 	ks.mtx.RLock()
-	key := ks.cache[keyID]
+	cached := ks.cache[keyID]
 	ks.mtx.RUnlock()
-	if key != nil {
-		return key, nil
+	if cached.key != nil && now.Before(cached.expires) {
+		return cached.key, nil
 	}
 
 	ks.mtx.Lock()
 	defer ks.mtx.Unlock()
 
 	// lookup in cache with write lock
-	key = ks.cache[keyID]
-	if key != nil {
-		return key, nil
+	cached = ks.cache[keyID]
+	if cached.key != nil && now.Before(cached.expires) {
+		return cached.key, nil
 	}
 
 	// lookup in vault
-	key, err = ks.lookupKey(keyID)
+	key, err := ks.lookupKey(keyID)
 	if err != nil {
+		delete(ks.cache, keyID)
 		return nil, err
 	}
 
 	// store in cache
-	ks.cache[keyID] = key
+	ttl := ks.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	ks.cache[keyID] = cachedKey{key: key, expires: now.Add(ttl)}
 	return key, nil
 }
 
+// Purge drops all cached keys. Cached keys also expire after CacheTTL.
+func (ks *KeySource) Purge() {
+	ks.mtx.Lock()
+	defer ks.mtx.Unlock()
+
+	ks.cache = make(map[string]cachedKey, 64)
+}
+
 func (ks *KeySource) lookupKey(keyID string) (interface{}, error) {
 	sec, err := ks.client.Logical().Read(ks.mountPath + "/key/" + keyID)
 	if err != nil {
 		return nil, err
 	}
 	if sec == nil {
-		return nil, errors.New("signing key not found")
+		return nil, ks.missingKey(keyID)
 	}
 
 	pemString, _ := sec.Data["public"].(string)
 	if pemString == "" {
-		return nil, errors.New("signing key not found")
+		return nil, ErrKeyNotFound
 	}
 
 	block, _ := pem.Decode([]byte(pemString))
 	if block == nil {
-		return nil, errors.New("signing key not found")
+		return nil, ErrKeyNotFound
 	}
 
-	if block.Type == "PUBLIC KEY" || strings.HasSuffix(block.Type, " PUBLIC KEY") {
-		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, errors.New("invalid signing key")
 	}
+}
+
+// missingKey returns ErrKeyRevoked when an unpublished key has been revoked
+// and ErrKeyNotFound otherwise. Revocations can be read without a token.
+func (ks *KeySource) missingKey(keyID string) error {
+	r := ks.client.NewRequest("GET", "/v1/"+ks.mountPath+"/revoked/"+keyID)
+
+	resp, err := ks.client.RawRequest(r)
+	if resp != nil {
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return ErrKeyRevoked
+		case http.StatusForbidden, http.StatusNotFound:
+			return ErrKeyNotFound
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return ErrKeyNotFound
+}
 
-	return nil, errors.New("invalid signing key")
+// isValidKeyID reports whether keyID is a valid key ID of the backend.
+func isValidKeyID(keyID string) bool {
+	return keyIDRegexp.MatchString(keyID)
 }
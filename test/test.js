@@ -18,7 +18,16 @@ describe('Roles', function() {
       name: 'role0',
       overrides: '',
       schema: '',
-      ttl: 3600
+      include_x5t: false,
+      key_ring: '',
+      max_ttl: 0,
+      signing_key: '',
+      ttl: 3600,
+      allowed_claims: [],
+      denied_claims: [],
+      allowed_claim_values: '',
+      unknown_claims: 'reject',
+      allowed_audiences: []
     });
 
     const resp3 = await write(`jwt/sign/${id}`, {
@@ -62,7 +71,16 @@ describe('Roles', function() {
       name: "role1",
       overrides: "{\"iss\":\"https://example.net\"}",
       schema: "{\"properties\":{\"scopes\":{\"type\":\"array\",\"items\":{\"type\":\"string\"}}}}",
-      ttl: 3600
+      include_x5t: false,
+      key_ring: "",
+      max_ttl: 0,
+      signing_key: "",
+      ttl: 3600,
+      allowed_claims: [],
+      denied_claims: [],
+      allowed_claim_values: "",
+      unknown_claims: "reject",
+      allowed_audiences: []
     });
 
     const resp3 = await write(`jwt/sign/${id}`, {